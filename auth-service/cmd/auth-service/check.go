@@ -0,0 +1,102 @@
+package main
+
+import (
+	"auth-service/internal/authcheck"
+	"auth-service/internal/config"
+	"auth-service/internal/globals"
+	"auth-service/internal/syslog"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// checkConfig validates config file and optionally checks every authentication server
+// with its auth_check method. Report is printed to stdout. It returns false if errors are found
+func checkConfig(args []string) bool {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	file := fs.String("config", "", "Full path to config file")
+	connect := fs.Bool("connect", false, "Check connection to every authentication server and syslog server")
+	fs.Parse(args)
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "Config file command line parameter must be present. Run with option -h for help")
+		return false
+	}
+	problems, err := config.CheckConfig(*file)
+	if err != nil {
+		fmt.Printf("ERROR    %s\n", err)
+		return false
+	}
+	errs, warnings := 0, 0
+	for _, p := range problems {
+		if p.Warning {
+			warnings++
+			fmt.Printf("WARNING  %s\n", p)
+		} else {
+			errs++
+			fmt.Printf("ERROR    %s\n", p)
+		}
+	}
+	if errs == 0 && *connect {
+		errs += checkServers(*file)
+	}
+	fmt.Printf("%s: %d errors, %d warnings\n", *file, errs, warnings)
+	return errs == 0
+}
+
+// checkServers returns number of servers which failed the check
+func checkServers(file string) int {
+	acfg := config.NewConfig()
+	if err := acfg.LoadConfig(file); err != nil {
+		fmt.Printf("ERROR    %s\n", err)
+		return 1
+	}
+	acfg.SetAppLogger(&globals.DummyLogger{})
+	checker := authcheck.NewChecker(acfg, authClient(acfg))
+	failed := checkSyslog(acfg)
+	for i := 0; i < acfg.NumAuthServers(); i++ {
+		m := acfg.AuthCheckMethod(i)
+		name := fmt.Sprintf("%s (%s, %s)", acfg.AuthServerName(i), acfg.AuthServerAddress(i), m)
+		if m == globals.AuthCheckCredentials && acfg.AuthCheckUser() == "" {
+			fmt.Printf("SKIPPED  %s: auth_check.user is not set\n", name)
+			continue
+		}
+		start := time.Now()
+		if err := checker.Probe(context.Background(), i); err != nil {
+			failed++
+			fmt.Printf("FAILED   %s: %s\n", name, err)
+			continue
+		}
+		fmt.Printf("OK       %s %s\n", name, time.Since(start).Round(time.Millisecond))
+	}
+	return failed
+}
+
+// checkSyslog opens connection to syslog servers of application log and audit.
+// It returns number of servers which are not reachable
+func checkSyslog(acfg *config.AppConfig) int {
+	sinks := make(map[string]syslog.Options)
+	if o, ok := acfg.LogSyslog(); ok {
+		sinks["log.syslog"] = o
+	}
+	if o, _, ok := acfg.AuditSyslog(); ok && acfg.IsAuditEnabled() {
+		sinks["audit.syslog"] = o
+	}
+	failed := 0
+	for _, path := range []string{"log.syslog", "audit.syslog"} {
+		o, ok := sinks[path]
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("%s (%s %s)", path, o.Network, o.Address)
+		start := time.Now()
+		if err := syslog.Probe(o); err != nil {
+			failed++
+			fmt.Printf("FAILED   %s: %s\n", name, err)
+			continue
+		}
+		fmt.Printf("OK       %s %s\n", name, time.Since(start).Round(time.Millisecond))
+	}
+	return failed
+}
@@ -1,26 +1,71 @@
 package main
 
 import (
+	"auth-service/internal/apikey"
 	"auth-service/internal/applog"
-	"auth-service/internal/authcheck"
+	"auth-service/internal/audit"
+	"auth-service/internal/authcache"
+	"auth-service/internal/certs"
+	"auth-service/internal/coalesce"
 	"auth-service/internal/config"
 	"auth-service/internal/globals"
 	"auth-service/internal/ldapc"
+	"auth-service/internal/metrics"
+	"auth-service/internal/offline"
+	"auth-service/internal/openapi"
+	"auth-service/internal/pwhash"
 	"auth-service/internal/radiusc"
+	"auth-service/internal/syslog"
+	"auth-service/internal/throttle"
 	"auth-service/internal/websrv"
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/term"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		if err := hashPassword(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen-api-key" {
+		key, hash, err := apikey.Generate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("key:  %s\nhash: %s\n", key, hash)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		b, err := websrv.NewOpenAPI("").JSON()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		if !checkConfig(os.Args[2:]) {
+			os.Exit(1)
+		}
+		return
+	}
 	config_file := flag.String("config", "", "Full path to config file")
 	flag.Parse()
 	if len(*config_file) <= 1 {
@@ -33,34 +78,160 @@ func main() {
 		return
 	}
 	p, lvl := acfg.LogConfig()
-	logger := applog.NewLogger(p, lvl)
+	var sw *syslog.Writer
+	if o, ok := acfg.LogSyslog(); ok {
+		var err error
+		if sw, err = syslog.New(o); err != nil {
+			log.Fatal(err)
+			return
+		}
+		sw.SetDropListener(func() { metrics.SyslogDropped("log") })
+		defer sw.Close()
+	}
+	logger := applog.NewLogger(p, lvl, sw)
 	acfg.SetAppLogger(logger)
-	run(acfg)
+	for _, p := range acfg.Warnings() {
+		logger.Warnf("Config warning. %s", p)
+	}
+	run(acfg, *config_file)
 }
 
-func run(acfg *config.AppConfig) {
+func run(acfg *config.AppConfig, configFile string) {
 	authClient := authClient(acfg)
 	srv := acfg.AvailableServersIDs()
 	acfg.SetAvailableServers(srv)
 	acfg.PrintConfig() //only if logging level is debug
-	if acfg.IsAuthCheckEnabled() {
-		go authcheck.StartAuthCheckTask(acfg, authClient)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	rl := newReloader(bgCtx, configFile, acfg, authClient)
+	rl.startChecks()
+	auditLog := auditLogger(acfg)
+	defer auditLog.Close()
+	var thr *throttle.Throttle
+	if acfg.IsThrottleEnabled() {
+		thr = throttle.New(acfg.ThrottleConfig())
+		thr.SetLockoutListener(metrics.Lockout)
+		go thr.Run(bgCtx)
+	}
+	rh := websrv.NewRouteHandler(acfg, authClient, auditLog, thr)
+	if acfg.IsDegradedModeEnabled() {
+		a, err := offline.New(acfg.DegradedModeConfig(), acfg.AppLogger())
+		if err != nil {
+			acfg.AppLogger().Fatalf("Unable to load degraded mode store. Error %s", err)
+		}
+		rh.SetDegradedMode(a)
 	}
-	rh := websrv.NewRouteHandler(acfg, authClient)
+	if acfg.IsAuthCacheEnabled() {
+		cache := authcache.New(acfg.AuthCacheTTL())
+		go cache.Run(bgCtx)
+		rh.SetCache(cache)
+		rl.cache = cache
+	}
+	if acfg.IsCoalesceEnabled() {
+		rh.SetCoalescing(coalesce.NewGroup())
+	}
+	// the cap works with and without coalescing
+	if max, window := acfg.MFALimit(); max > 0 {
+		limiter := coalesce.NewUserLimiter(max, window)
+		go limiter.Run(bgCtx)
+		rh.SetMFALimit(limiter)
+	}
+	rh.SetReloader(rl.reload)
 	r := setupRoutes(acfg, rh)
-	httpSrv := websrv.Run(acfg.AppLogger(), acfg.WebSrvConfig(), r)
+	var tlsCfg *tls.Config
+	if acfg.WebSrvConfig().IsSSLEnabled() {
+		cr, err := certs.New(acfg.TLSOptions(), acfg.AppLogger())
+		if err != nil {
+			acfg.AppLogger().Fatalf("Unable to load tls certificates. Error %s", err)
+		}
+		go cr.Run(bgCtx)
+		tlsCfg = cr.TLSConfig()
+	}
+	httpSrv := websrv.Run(acfg.AppLogger(), acfg.WebSrvConfig(), r, tlsCfg)
+	var metricsSrv *http.Server
+	if acfg.IsMetricsEnabled() {
+		metrics.RegisterServersState(acfg.ServersState)
+		if addr := acfg.MetricsListenAddress(); addr != "" {
+			metricsSrv = websrv.RunMetrics(acfg.AppLogger(), addr, metricsRoutes(acfg, rh))
+		}
+	}
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 	defer signal.Stop(stop)
-	<-stop
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for running := true; running; {
+		select {
+		case <-hup:
+			acfg.AppLogger().Info("Config reload requested by SIGHUP")
+			rl.reload()
+		case <-stop:
+			running = false
+		}
+	}
+	stopBackground()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := httpSrv.Shutdown(ctx); err != nil {
 		acfg.AppLogger().Fatalf("Server shutdown failed: %s", err)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			acfg.AppLogger().Fatalf("Metrics server shutdown failed: %s", err)
+		}
+	}
 	acfg.AppLogger().Info("Auth service stopped")
 }
 
+// hashPassword reads password from terminal or stdin and prints its hash for break-glass accounts
+func hashPassword() error {
+	var pass string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		pass = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		pass = strings.TrimRight(line, "\r\n")
+	}
+	if pass == "" {
+		return errors.New("password is empty")
+	}
+	hash, err := pwhash.Hash(pass, pwhash.DefaultIterations)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// auditLogger returns nil if audit is disabled
+func auditLogger(c *config.AppConfig) *audit.Logger {
+	if !c.IsAuditEnabled() {
+		return nil
+	}
+	var sinks []audit.Sink
+	if fo := c.AuditFileOptions(); fo.File != "" {
+		sinks = append(sinks, audit.NewFileSink(fo))
+	}
+	if o, format, ok := c.AuditSyslog(); ok {
+		s, err := audit.NewSyslogSink(o, format, func() { metrics.SyslogDropped("audit") })
+		if err != nil {
+			c.AppLogger().Fatalf("Unable to create audit syslog sink. Error %s", err)
+		}
+		sinks = append(sinks, s)
+	}
+	return audit.New(c.AppLogger(), sinks...)
+}
+
 func authClient(c *config.AppConfig) globals.AuthClientProvider {
 	switch c.AuthProviderType() {
 	case globals.AuthProviderRadius:
@@ -75,11 +246,58 @@ func setupRoutes(c *config.AppConfig, rh *websrv.RouteHandler) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
-	r.POST("/auth", rh.AuthenticateUser)
 	if c.IsMonitoringEnabled() {
 		c.AppLogger().Debugf("Monitoring path is %s", c.GetMonitoringPath())
-		c.AppLogger().Debugf("Monitoring api key is %s", c.GetMonitoringApiKey())
-		r.GET(c.GetMonitoringPath(), rh.Status)
 	}
+	// signatures of requests are checked if enabled. Metrics are scraped without signature
+	apiRoutes(c, rh, r.Group("/", rh.VerifySignature), c.GetMonitoringPath())
+	// versioned api answers errors with reason codes
+	apiRoutes(c, rh, r.Group("/v1", rh.V1, rh.VerifySignature), "/status")
+	metricsPath := ""
+	if c.IsMetricsEnabled() && c.MetricsListenAddress() == "" {
+		r.GET(c.GetMetricsPath(), rh.Metrics)
+		metricsPath = c.GetMetricsPath()
+	}
+	doc := websrv.NewOpenAPI(metricsPath)
+	r.GET(openapi.Path, websrv.OpenAPI(doc))
+	checkOpenAPI(c, doc, r.Routes())
+	return r
+}
+
+// checkOpenAPI warns about routes missing in OpenAPI document. Monitoring path of legacy api
+// is not described on purpose
+func checkOpenAPI(c *config.AppConfig, doc *openapi.Document, routes gin.RoutesInfo) {
+	for _, ri := range routes {
+		if ri.Path == c.GetMonitoringPath() {
+			continue
+		}
+		if !doc.Has(ri.Method, ri.Path) {
+			c.AppLogger().Warnf("Route %s %s is not described in OpenAPI document", ri.Method, ri.Path)
+		}
+	}
+}
+
+// apiRoutes registers routes of api in g. Status is served at statusPath
+func apiRoutes(c *config.AppConfig, rh *websrv.RouteHandler, g *gin.RouterGroup, statusPath string) {
+	g.POST("/auth", rh.AuthenticateUser)
+	if c.IsAccountingEnabled() {
+		g.POST("/accounting", rh.Accounting)
+	}
+	if c.IsMonitoringEnabled() {
+		g.GET(statusPath, rh.Status)
+	}
+	if c.IsAdminEnabled() {
+		admin := g.Group("/admin", rh.AdminAuth)
+		admin.GET("/lockouts", rh.Lockouts)
+		admin.DELETE("/lockouts", rh.ClearLockouts)
+		admin.DELETE("/cache", rh.ClearCache)
+		admin.POST("/reload", rh.Reload)
+	}
+}
+
+func metricsRoutes(c *config.AppConfig, rh *websrv.RouteHandler) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET(c.GetMetricsPath(), rh.Metrics)
 	return r
 }
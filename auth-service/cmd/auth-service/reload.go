@@ -0,0 +1,69 @@
+package main
+
+import (
+	"auth-service/internal/authcache"
+	"auth-service/internal/authcheck"
+	"auth-service/internal/config"
+	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"context"
+	"sync"
+)
+
+// reloader applies changes of config file. Checks of servers are stopped during reload
+// and started again with the new list of servers
+type reloader struct {
+	m          sync.Mutex
+	file       string
+	acfg       *config.AppConfig
+	client     globals.AuthClientProvider
+	ctx        context.Context
+	stopChecks context.CancelFunc
+	checksDone chan struct{}
+	cache      *authcache.Cache
+}
+
+func newReloader(ctx context.Context, file string, acfg *config.AppConfig, client globals.AuthClientProvider) *reloader {
+	return &reloader{ctx: ctx, file: file, acfg: acfg, client: client}
+}
+
+// startChecks starts checks of servers if they are enabled
+func (r *reloader) startChecks() {
+	r.stopChecks, r.checksDone = nil, nil
+	if !r.acfg.IsAuthCheckEnabled() {
+		r.acfg.SetServerFailureListener(nil)
+		return
+	}
+	checker := authcheck.NewChecker(r.acfg, r.client)
+	r.acfg.SetServerFailureListener(checker.Recheck)
+	ctx, cancel := context.WithCancel(r.ctx)
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+	r.stopChecks, r.checksDone = cancel, done
+}
+
+// reload replaces config. On error the current config is kept and checks are resumed
+func (r *reloader) reload() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.stopChecks != nil {
+		r.stopChecks()
+		<-r.checksDone
+	}
+	err := r.acfg.Reload(r.file)
+	if err != nil {
+		r.acfg.AppLogger().Errorf("Config reload failed, current config is kept. Error %s", err)
+	} else if !r.acfg.IsAuthCheckEnabled() {
+		r.acfg.SetAvailableServers(r.acfg.AvailableServersIDs())
+	}
+	r.startChecks()
+	metrics.ConfigReload(err == nil)
+	if err == nil && r.cache != nil {
+		// cached results were made by servers which may be removed from config
+		r.cache.Invalidate("")
+	}
+	return err
+}
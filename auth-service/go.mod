@@ -6,13 +6,14 @@ require (
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-ldap/ldap/v3 v3.4.1
 	github.com/go-playground/validator/v10 v10.4.1 // indirect
-	github.com/golang/protobuf v1.4.3 // indirect
-	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/mitchellh/mapstructure v1.4.0
+	github.com/prometheus/client_golang v1.11.1
 	github.com/spf13/viper v1.7.1
 	github.com/ugorji/go v1.2.0 // indirect
 	go.uber.org/zap v1.16.0
-	google.golang.org/protobuf v1.25.0 // indirect
+	golang.org/x/crypto v0.13.0
+	golang.org/x/term v0.12.0
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	layeh.com/radius v0.0.0-20231213012653-1006025d24f8
 )
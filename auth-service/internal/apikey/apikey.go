@@ -0,0 +1,137 @@
+package apikey
+
+import (
+	"auth-service/internal/pwhash"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// scopes of api keys
+const (
+	// /auth and /accounting
+	ScopeAuth    = "auth"
+	ScopeStatus  = "status"
+	ScopeMetrics = "metrics"
+	ScopeAdmin   = "admin"
+)
+
+// Iterations of PBKDF2 used for hashes of generated keys. Keys are random,
+// so low count is enough and checks of requests stay fast
+const Iterations = 1000
+
+// length of generated key in bytes before encoding
+const keySize = 32
+
+// Key is api key of one caller
+type Key struct {
+	Name string
+	// hash created by Generate. Empty for plain keys
+	Hash string
+	// value of plain key, used if Hash is empty
+	Value  string
+	Scopes []string
+	// key is not valid before NotBefore and after Expires. Zero time means no limit
+	NotBefore time.Time
+	Expires   time.Time
+}
+
+func (k *Key) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Key) active(now time.Time) bool {
+	return (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) && (k.Expires.IsZero() || now.Before(k.Expires))
+}
+
+func (k *Key) matches(key string) bool {
+	if k.Hash == "" {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(k.Value)) == 1
+	}
+	ok, err := pwhash.Verify(key, k.Hash)
+	return err == nil && ok
+}
+
+// Store verifies api keys of requests. Nil store has no keys
+type Store struct {
+	keys []Key
+}
+
+// ValidScope reports if scope is known
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeAuth, ScopeStatus, ScopeMetrics, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+func New(keys []Key) (*Store, error) {
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, errors.New("name of api key is empty")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("duplicate api key %s", k.Name)
+		}
+		names[k.Name] = true
+		if k.Hash != "" {
+			if err := pwhash.Check(k.Hash); err != nil {
+				return nil, fmt.Errorf("api key %s: %w", k.Name, err)
+			}
+		}
+		for _, s := range k.Scopes {
+			if !ValidScope(s) {
+				return nil, fmt.Errorf("api key %s: unknown scope %s", k.Name, s)
+			}
+		}
+	}
+	return &Store{keys: keys}, nil
+}
+
+// Has reports if any key is configured for scope
+func (s *Store) Has(scope string) bool {
+	if s == nil {
+		return false
+	}
+	for i := range s.keys {
+		if s.keys[i].hasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify returns name of key which matches key, has scope and is active at now
+func (s *Store) Verify(key, scope string, now time.Time) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	for i := range s.keys {
+		k := &s.keys[i]
+		if k.hasScope(scope) && k.active(now) && k.matches(key) {
+			return k.Name, true
+		}
+	}
+	return "", false
+}
+
+// Generate returns new random key and its hash for config
+func Generate() (key, hash string, err error) {
+	b := make([]byte, keySize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = base64.RawURLEncoding.EncodeToString(b)
+	hash, err = pwhash.Hash(key, Iterations)
+	return key, hash, err
+}
@@ -0,0 +1,107 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	generated, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New([]Key{
+		{Name: "vpn", Value: "plain-key", Scopes: []string{ScopeAuth}},
+		{Name: "monitoring", Hash: hash, Scopes: []string{ScopeStatus, ScopeMetrics}},
+		{Name: "rotated", Value: "rotated-key", Scopes: []string{ScopeAdmin},
+			NotBefore: now.Add(-time.Hour), Expires: now.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		key   string
+		scope string
+		at    time.Time
+		want  string
+		ok    bool
+	}{
+		{"plain key", "plain-key", ScopeAuth, now, "vpn", true},
+		{"hashed key", generated, ScopeStatus, now, "monitoring", true},
+		{"hashed key of other scope", generated, ScopeMetrics, now, "monitoring", true},
+		{"scope of key is missing", "plain-key", ScopeAdmin, now, "", false},
+		{"hashed key without scope", generated, ScopeAuth, now, "", false},
+		{"wrong key", "plain-key2", ScopeAuth, now, "", false},
+		{"wrong hashed key", generated + "x", ScopeStatus, now, "", false},
+		{"empty key", "", ScopeAuth, now, "", false},
+		{"hash is not key", hash, ScopeStatus, now, "", false},
+		{"unknown scope", "plain-key", "other", now, "", false},
+		{"before not_before", "rotated-key", ScopeAdmin, now.Add(-time.Hour - time.Second), "", false},
+		{"at not_before", "rotated-key", ScopeAdmin, now.Add(-time.Hour), "rotated", true},
+		{"before expires", "rotated-key", ScopeAdmin, now.Add(time.Hour - time.Second), "rotated", true},
+		{"at expires", "rotated-key", ScopeAdmin, now.Add(time.Hour), "", false},
+		{"after expires", "rotated-key", ScopeAdmin, now.Add(2 * time.Hour), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, ok := s.Verify(tt.key, tt.scope, tt.at)
+			if name != tt.want || ok != tt.ok {
+				t.Errorf("Verify() = %q, %v, want %q, %v", name, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHas(t *testing.T) {
+	s, err := New([]Key{{Name: "vpn", Value: "plain-key", Scopes: []string{ScopeAuth, ScopeStatus}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var empty *Store
+	tests := []struct {
+		name  string
+		s     *Store
+		scope string
+		want  bool
+	}{
+		{"auth", s, ScopeAuth, true},
+		{"status", s, ScopeStatus, true},
+		{"metrics", s, ScopeMetrics, false},
+		{"admin", s, ScopeAdmin, false},
+		{"nil store", empty, ScopeAuth, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Has(tt.scope); got != tt.want {
+				t.Errorf("Has() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, ok := empty.Verify("plain-key", ScopeAuth, time.Now()); ok {
+		t.Error("nil store: Verify() = true")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+		ok   bool
+	}{
+		{"valid", []Key{{Name: "vpn", Value: "k", Scopes: []string{ScopeAuth}}}, true},
+		{"no keys", nil, true},
+		{"empty name", []Key{{Value: "k", Scopes: []string{ScopeAuth}}}, false},
+		{"duplicate", []Key{{Name: "vpn", Value: "k"}, {Name: "vpn", Value: "k2"}}, false},
+		{"unknown scope", []Key{{Name: "vpn", Value: "k", Scopes: []string{"all"}}}, false},
+		{"malformed hash", []Key{{Name: "vpn", Hash: "not a hash", Scopes: []string{ScopeAuth}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.keys); (err == nil) != tt.ok {
+				t.Errorf("New() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
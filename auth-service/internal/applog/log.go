@@ -1,6 +1,8 @@
 package applog
 
 import (
+	"auth-service/internal/syslog"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -43,7 +45,8 @@ func NewQAAppLogger() *zap.SugaredLogger {
 	return slogger
 }
 
-func NewLogger(p string, logLvlStr string) *zap.SugaredLogger {
+// NewLogger creates app logger. If sw is not nil, messages are sent to syslog as well
+func NewLogger(p string, logLvlStr string, sw *syslog.Writer) *zap.SugaredLogger {
 	var lvl zapcore.Level
 
 	switch logLvlStr {
@@ -59,5 +62,27 @@ func NewLogger(p string, logLvlStr string) *zap.SugaredLogger {
 		lvl = zap.ErrorLevel
 	}
 
-	return NewProductionAppLogger(p, lvl)
+	logger := NewProductionAppLogger(p, lvl)
+	if sw != nil {
+		logger = logger.Desugar().WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(c, syslogCore(sw, lvl))
+		})).Sugar()
+	}
+	return logger
+}
+
+// syslogCore writes json messages to syslog with severity of message level
+func syslogCore(sw *syslog.Writer, lvl zapcore.Level) zapcore.Core {
+	ecfg := zap.NewProductionEncoderConfig()
+	ecfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	enc := zapcore.NewJSONEncoder(ecfg)
+	band := func(min, max zapcore.Level) zap.LevelEnablerFunc {
+		return func(l zapcore.Level) bool { return l >= lvl && l >= min && l <= max }
+	}
+	return zapcore.NewTee(
+		zapcore.NewCore(enc, sw.Severity(syslog.SevDebug), band(zapcore.DebugLevel, zapcore.DebugLevel)),
+		zapcore.NewCore(enc, sw.Severity(syslog.SevInfo), band(zapcore.InfoLevel, zapcore.InfoLevel)),
+		zapcore.NewCore(enc, sw.Severity(syslog.SevWarning), band(zapcore.WarnLevel, zapcore.WarnLevel)),
+		zapcore.NewCore(enc, sw.Severity(syslog.SevError), band(zapcore.ErrorLevel, zapcore.FatalLevel)),
+	)
 }
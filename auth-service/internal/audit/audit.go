@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"auth-service/internal/globals"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Event is one authentication decision. Password is never recorded
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	User      string    `json:"user"`
+	ClientIP  string    `json:"client_ip"`
+	Provider  string    `json:"provider"`
+	// ApiKey is name of api key of caller
+	ApiKey string `json:"api_key,omitempty"`
+	// ClientCert is name of caller identified by client certificate
+	ClientCert string `json:"client_cert,omitempty"`
+	// Server is name of authentication server which made the decision
+	Server string `json:"server,omitempty"`
+	// Cached is true if result was taken from cache of successful authentications
+	Cached bool `json:"cached,omitempty"`
+	// Degraded is true if none of authentication servers answered and user was verified locally
+	Degraded  bool    `json:"degraded,omitempty"`
+	Result    string  `json:"result"`
+	Reason    string  `json:"reason,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// Sink writes audit events to some storage
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// Logger sends audit events to all sinks. Nil logger discards events
+type Logger struct {
+	sinks []Sink
+	l     globals.AppLogger
+}
+
+func New(l globals.AppLogger, sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks, l: l}
+}
+
+// Record writes event to all sinks. Failure of sink is logged to application log
+func (a *Logger) Record(e *Event) {
+	if a == nil {
+		return
+	}
+	for _, s := range a.sinks {
+		if err := s.Write(e); err != nil {
+			a.l.Errorf("Unable to write audit event %s. Error %s", e.RequestID, err)
+		}
+	}
+}
+
+func (a *Logger) Close() {
+	if a == nil {
+		return
+	}
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			a.l.Error(err)
+		}
+	}
+}
+
+// NewRequestID returns random id of request
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
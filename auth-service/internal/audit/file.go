@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileOptions configures rotation of audit file
+type FileOptions struct {
+	File       string
+	MaxSizeMb  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+}
+
+// fileSink writes events as JSON lines to rotated file
+type fileSink struct {
+	m sync.Mutex
+	w *lumberjack.Logger
+}
+
+func NewFileSink(o FileOptions) Sink {
+	return &fileSink{w: &lumberjack.Logger{
+		Filename:   o.File,
+		MaxSize:    o.MaxSizeMb,
+		MaxBackups: o.MaxBackups,
+		MaxAge:     o.MaxAgeDays,
+		Compress:   o.Compress,
+	}}
+}
+
+func (fs *fileSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	_, err = fs.w.Write(b)
+	return err
+}
+
+func (fs *fileSink) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	return fs.w.Close()
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"auth-service/internal/globals"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// formats of audit events
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+)
+
+const (
+	vendor         = "openvpn-multi-auth"
+	product        = "auth-service"
+	productVersion = "1.0"
+)
+
+// Format returns formatter of events by name. Empty name means json
+func Format(name string) (func(e *Event) (string, error), error) {
+	switch name {
+	case "", FormatJSON:
+		return formatJSON, nil
+	case FormatCEF:
+		return formatCEF, nil
+	case FormatLEEF:
+		return formatLEEF, nil
+	}
+	return nil, fmt.Errorf("unknown audit format %s", name)
+}
+
+func formatJSON(e *Event) (string, error) {
+	b, err := json.Marshal(e)
+	return string(b), err
+}
+
+// cefSeverity maps result of authentication to CEF severity 0-10
+func cefSeverity(e *Event) int {
+	if e.Degraded {
+		return 8
+	}
+	switch e.Result {
+	case globals.OutcomeAccept, globals.OutcomeChallenge:
+		return 3
+	case globals.OutcomeReject:
+		return 5
+	case globals.OutcomeForbidden, globals.OutcomeLocked, globals.OutcomeLimited:
+		return 7
+	}
+	return 8
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefEscaper         = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// formatCEF formats event in ArcSight Common Event Format
+func formatCEF(e *Event) (string, error) {
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Time.UnixNano()/1e6, 10),
+		"suser=" + cefExtensionEscaper.Replace(e.User),
+		"src=" + cefExtensionEscaper.Replace(e.ClientIP),
+		"outcome=" + e.Result,
+		"externalId=" + cefExtensionEscaper.Replace(e.RequestID),
+		"cs1Label=provider",
+		"cs1=" + e.Provider,
+		"cs2Label=server",
+		"cs2=" + cefExtensionEscaper.Replace(e.Server),
+		"reason=" + cefExtensionEscaper.Replace(e.Reason),
+		"cn1Label=latencyMs",
+		"cn1=" + strconv.FormatInt(int64(e.LatencyMs), 10),
+		"cs4Label=apiKey",
+		"cs4=" + cefExtensionEscaper.Replace(e.ApiKey),
+		"cs5Label=clientCert",
+		"cs5=" + cefExtensionEscaper.Replace(e.ClientCert),
+	}
+	if e.Degraded {
+		ext = append(ext, "cs3Label=mode", "cs3=degraded")
+	}
+	return fmt.Sprintf("CEF:0|%s|%s|%s|auth-%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(vendor),
+		cefHeaderEscaper.Replace(product),
+		productVersion,
+		e.Result,
+		cefHeaderEscaper.Replace("Authentication "+e.Result),
+		cefSeverity(e),
+		strings.Join(ext, " "),
+	), nil
+}
+
+// formatLEEF formats event in IBM QRadar Log Event Extended Format 1.0
+func formatLEEF(e *Event) (string, error) {
+	attrs := []string{
+		"devTime=" + strconv.FormatInt(e.Time.UnixNano()/1e6, 10),
+		"usrName=" + leefEscaper.Replace(e.User),
+		"src=" + leefEscaper.Replace(e.ClientIP),
+		"result=" + e.Result,
+		"requestId=" + leefEscaper.Replace(e.RequestID),
+		"provider=" + e.Provider,
+		"server=" + leefEscaper.Replace(e.Server),
+		"reason=" + leefEscaper.Replace(e.Reason),
+		"latencyMs=" + strconv.FormatInt(int64(e.LatencyMs), 10),
+		"sev=" + strconv.Itoa(cefSeverity(e)),
+		"apiKey=" + leefEscaper.Replace(e.ApiKey),
+		"clientCert=" + leefEscaper.Replace(e.ClientCert),
+	}
+	if e.Degraded {
+		attrs = append(attrs, "mode=degraded")
+	}
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|auth-%s|%s",
+		vendor, product, productVersion, e.Result, strings.Join(attrs, "\t")), nil
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/syslog"
+)
+
+const syslogMsgID = "auth"
+
+// syslogSink sends events to syslog server in json, CEF or LEEF format
+type syslogSink struct {
+	w      *syslog.Writer
+	format func(e *Event) (string, error)
+}
+
+// NewSyslogSink creates sink of syslog server. onDrop is called for every event dropped
+// because the queue of syslog writer is full, it may be nil
+func NewSyslogSink(o syslog.Options, format string, onDrop func()) (Sink, error) {
+	f, err := Format(format)
+	if err != nil {
+		return nil, err
+	}
+	w, err := syslog.New(o)
+	if err != nil {
+		return nil, err
+	}
+	w.SetDropListener(onDrop)
+	return &syslogSink{w: w, format: f}, nil
+}
+
+func (ss *syslogSink) Write(e *Event) error {
+	msg, err := ss.format(e)
+	if err != nil {
+		return err
+	}
+	return ss.w.WriteMessage(syslogSeverity(e), syslogMsgID, msg)
+}
+
+func (ss *syslogSink) Close() error {
+	return ss.w.Close()
+}
+
+func syslogSeverity(e *Event) int {
+	if e.Degraded {
+		return syslog.SevWarning
+	}
+	switch e.Result {
+	case globals.OutcomeAccept, globals.OutcomeChallenge:
+		return syslog.SevInfo
+	case globals.OutcomeReject:
+		return syslog.SevNotice
+	case globals.OutcomeForbidden, globals.OutcomeLocked, globals.OutcomeLimited:
+		return syslog.SevWarning
+	}
+	return syslog.SevError
+}
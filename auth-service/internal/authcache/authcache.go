@@ -0,0 +1,110 @@
+package authcache
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/pwhash"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entries live only in memory for short time, so less iterations than for stored hashes are enough
+const iterations = 10000
+
+type entry struct {
+	user    string
+	hash    string
+	res     *globals.AuthResult
+	expires time.Time
+}
+
+// Cache remembers successful authentications by user, client ip and salted hash of password
+type Cache struct {
+	m       sync.Mutex
+	ttl     time.Duration
+	entries map[string]*entry
+}
+
+func New(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: make(map[string]*entry)}
+}
+
+// Get returns cached result if user from client ip was authenticated with the same password
+func (c *Cache) Get(user, clientIP, pass string) (*globals.AuthResult, bool) {
+	k := key(user, clientIP)
+	c.m.Lock()
+	e, ok := c.entries[k]
+	c.m.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	// hash is verified without lock, it is slow
+	if match, err := pwhash.Verify(pass, e.hash); err != nil || !match {
+		return nil, false
+	}
+	return e.res, true
+}
+
+// Put caches successful result
+func (c *Cache) Put(user, clientIP, pass string, res *globals.AuthResult) error {
+	hash, err := pwhash.Hash(pass, iterations)
+	if err != nil {
+		return err
+	}
+	e := &entry{
+		user:    strings.ToLower(user),
+		hash:    hash,
+		res:     res,
+		expires: time.Now().Add(c.ttl),
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.entries[key(user, clientIP)] = e
+	return nil
+}
+
+// Invalidate removes all cached results of user. Empty user removes all results.
+// Returns number of removed entries
+func (c *Cache) Invalidate(user string) int {
+	user = strings.ToLower(user)
+	c.m.Lock()
+	defer c.m.Unlock()
+	if user == "" {
+		n := len(c.entries)
+		c.entries = make(map[string]*entry)
+		return n
+	}
+	n := 0
+	for k, e := range c.entries {
+		if e.user == user {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// Run periodically removes expired entries until ctx is done
+func (c *Cache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.m.Lock()
+			for k, e := range c.entries {
+				if now.After(e.expires) {
+					delete(c.entries, k)
+				}
+			}
+			c.m.Unlock()
+		}
+	}
+}
+
+func key(user, clientIP string) string {
+	return strings.ToLower(user) + "\x00" + clientIP
+}
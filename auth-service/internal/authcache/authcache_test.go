@@ -0,0 +1,117 @@
+package authcache
+
+import (
+	"auth-service/internal/globals"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	c := New(time.Minute)
+	res := &globals.AuthResult{Accepted: true, Server: "r1"}
+	if err := c.Put("User", "10.0.0.1", "pass", res); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		user, ip, pass string
+		hit            bool
+	}{
+		{"same credentials", "User", "10.0.0.1", "pass", true},
+		{"user name case", "user", "10.0.0.1", "pass", true},
+		{"other password", "User", "10.0.0.1", "pass2", false},
+		{"empty password", "User", "10.0.0.1", "", false},
+		{"other client ip", "User", "10.0.0.2", "pass", false},
+		{"other user", "other", "10.0.0.1", "pass", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hit := c.Get(tt.user, tt.ip, tt.pass)
+			if hit != tt.hit || (hit && got != res) {
+				t.Errorf("Get() = %v, %v, want hit %v", got, hit, tt.hit)
+			}
+		})
+	}
+}
+
+func TestPasswordIsHashed(t *testing.T) {
+	c := New(time.Minute)
+	if err := c.Put("user", "10.0.0.1", "pass", &globals.AuthResult{Accepted: true}); err != nil {
+		t.Fatal(err)
+	}
+	e := c.entries[key("user", "10.0.0.1")]
+	if strings.Contains(e.hash, "pass") || !strings.HasPrefix(e.hash, "pbkdf2-sha256$") {
+		t.Errorf("entry keeps %q, want salted hash", e.hash)
+	}
+	// new password of the same user and client ip replaces the entry
+	if err := c.Put("user", "10.0.0.1", "new", &globals.AuthResult{Accepted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, hit := c.Get("user", "10.0.0.1", "pass"); hit {
+		t.Error("old password is accepted after new one was cached")
+	}
+	if _, hit := c.Get("user", "10.0.0.1", "new"); !hit {
+		t.Error("new password is not cached")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		// time passed since Put
+		age time.Duration
+		hit bool
+	}{
+		{"fresh", 0, true},
+		{"before ttl", 59 * time.Second, true},
+		{"after ttl", 61 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(time.Minute)
+			if err := c.Put("user", "10.0.0.1", "pass", &globals.AuthResult{Accepted: true}); err != nil {
+				t.Fatal(err)
+			}
+			c.entries[key("user", "10.0.0.1")].expires = c.entries[key("user", "10.0.0.1")].expires.Add(-tt.age)
+			if _, hit := c.Get("user", "10.0.0.1", "pass"); hit != tt.hit {
+				t.Errorf("Get() hit = %v, want %v", hit, tt.hit)
+			}
+		})
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		removed int
+		// users which are still cached
+		left []string
+	}{
+		{"user from all client ips", "ALICE", 2, []string{"bob"}},
+		{"unknown user", "carol", 0, []string{"alice", "bob"}},
+		{"all", "", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(time.Minute)
+			for _, u := range []struct{ user, ip string }{{"alice", "10.0.0.1"}, {"Alice", "10.0.0.2"}, {"bob", "10.0.0.1"}} {
+				if err := c.Put(u.user, u.ip, "pass", &globals.AuthResult{Accepted: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := c.Invalidate(tt.user); n != tt.removed {
+				t.Errorf("Invalidate() = %d, want %d", n, tt.removed)
+			}
+			if len(c.entries) != 3-tt.removed {
+				t.Errorf("%d entries left, want %d", len(c.entries), 3-tt.removed)
+			}
+			for _, u := range tt.left {
+				if _, hit := c.Get(u, "10.0.0.1", "pass"); !hit {
+					t.Errorf("user %s is not cached", u)
+				}
+			}
+		})
+	}
+}
@@ -2,72 +2,221 @@ package authcheck
 
 import (
 	"auth-service/internal/globals"
-	"sort"
+	"auth-service/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 )
 
+// min time between checks of one server when recheck is requested by live traffic
+const minRecheckInterval = time.Second
+
 type ConfigProvider interface {
 	NumAuthServers() int
 	AuthCheckUser() string
 	AuthCheckPass() string
-	AuthCheckInterval() int
+	AuthCheckInterval(i int) time.Duration
+	AuthCheckMethod(i int) string
+	AuthCheckJitter() time.Duration
+	AuthCheckTimeout() time.Duration
+	AuthCheckRise() int
+	AuthCheckFall() int
 	AppLogger() globals.AppLogger
 	AuthServerName(i int) string
 	SetAvailableServers(arr []int)
 	SetUnavailableServers(arr []int)
+	ReportCheck(i int, err error)
+	IsAuthServerAvailable(i int) bool
+}
+
+// Checker periodically checks every authentication server on its own schedule.
+// Server is marked unavailable after fall consecutive failed checks and available
+// again after rise consecutive successful checks
+type Checker struct {
+	cfg    ConfigProvider
+	client globals.AuthClientProvider
+	l      globals.AppLogger
+	m      sync.Mutex
+	up     []bool
+	// consecutive results of checks
+	successes []int
+	failures  []int
+	recheck   []chan struct{}
 }
 
-// //TODO: configProvider, radius client interface, сделать через интерфейсы
-// // чтобы можно было не зависеть от реализации: LDAP или Radius
-func StartAuthCheckTask(cfg ConfigProvider, client globals.AuthClientProvider) {
-	logger := cfg.AppLogger()
+// NewChecker takes initial state of servers from config, so state is kept when checker is recreated on reload
+func NewChecker(cfg ConfigProvider, client globals.AuthClientProvider) *Checker {
 	num := cfg.NumAuthServers()
-	availableServers := make([]int, num)
-	unavailableServers := make([]int, num)
-	user := cfg.AuthCheckUser()
-	pass := cfg.AuthCheckPass()
-	interval := time.Duration(cfg.AuthCheckInterval()) * time.Second
-	var m, m2 sync.Mutex
+	ch := &Checker{
+		cfg:       cfg,
+		client:    client,
+		l:         cfg.AppLogger(),
+		up:        make([]bool, num),
+		successes: make([]int, num),
+		failures:  make([]int, num),
+		recheck:   make([]chan struct{}, num),
+	}
+	for i := 0; i < num; i++ {
+		ch.up[i] = cfg.IsAuthServerAvailable(i)
+		ch.recheck[i] = make(chan struct{}, 1)
+	}
+	return ch
+}
+
+// Run starts checks of all servers and blocks until ctx is done
+func (ch *Checker) Run(ctx context.Context) {
 	var wg sync.WaitGroup
+	for i := range ch.up {
+		wg.Add(1)
+		go func(i2 int) {
+			defer wg.Done()
+			ch.runServer(ctx, i2)
+		}(i)
+	}
+	wg.Wait()
+	ch.l.Debug("Authentication checks stopped")
+}
+
+// Recheck requests immediate check of server. It is called when live traffic reports failure
+func (ch *Checker) Recheck(idx int) {
+	if idx < 0 || idx >= len(ch.recheck) {
+		return
+	}
+	select {
+	case ch.recheck[idx] <- struct{}{}:
+	default:
+	}
+}
+
+func (ch *Checker) runServer(ctx context.Context, idx int) {
+	// spread the first checks of servers
+	if !sleep(ctx, ch.jitter()) {
+		return
+	}
 	for {
-		availableServers = availableServers[:0]
-		unavailableServers = unavailableServers[:0]
-		logger.Debug("Start monitoring authentication check")
-		for i := 0; i < num; i++ {
-			wg.Add(1)
-			go func(i2 int) {
-				defer wg.Done()
-				r, err := client.CheckAuthenticateUser(user, pass, i2)
-				if err != nil {
-					logger.Errorf("Server %s is unavailable. Error %s", cfg.AuthServerName(i2), err)
-					m2.Lock()
-					defer m2.Unlock()
-					unavailableServers = append(unavailableServers, i2)
-					return
-				}
-				if !r {
-					logger.Errorf("Unable authenticate monitoring user. Server %s is unavailable", cfg.AuthServerName(i2))
-					m2.Lock()
-					defer m2.Unlock()
-					unavailableServers = append(unavailableServers, i2)
-					return
-				}
-				m.Lock()
-				availableServers = append(availableServers, i2)
-				m.Unlock()
+		ok := ch.check(ctx, idx)
+		if ctx.Err() != nil {
+			return
+		}
+		ch.report(idx, ok)
+		timer := time.NewTimer(ch.cfg.AuthCheckInterval(idx) + ch.jitter())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-ch.recheck[idx]:
+			timer.Stop()
+			ch.l.Debugf("Recheck of server %s is requested", ch.cfg.AuthServerName(idx))
+			if !sleep(ctx, minRecheckInterval) {
+				return
+			}
+		}
+	}
+}
+
+func (ch *Checker) check(ctx context.Context, idx int) bool {
+	start := time.Now()
+	err := ch.probe(ctx, idx)
+	// service is stopping
+	if ctx.Err() != nil {
+		return false
+	}
+	ch.cfg.ReportCheck(idx, err)
+	metrics.ObserveHealthCheck(ch.cfg.AuthServerName(idx), err == nil, time.Since(start))
+	if err != nil {
+		ch.l.Warnf("Check of server %s failed. Error %s", ch.cfg.AuthServerName(idx), err)
+		return false
+	}
+	return true
+}
+
+// Probe checks server idx once with configured method. Result is not recorded
+func (ch *Checker) Probe(ctx context.Context, idx int) error {
+	return ch.probe(ctx, idx)
+}
 
-			}(i)
+// probe checks server with configured method
+func (ch *Checker) probe(ctx context.Context, idx int) error {
+	ctx, cancel := context.WithTimeout(ctx, ch.cfg.AuthCheckTimeout())
+	defer cancel()
+	if m := ch.cfg.AuthCheckMethod(idx); m != globals.AuthCheckCredentials {
+		p, ok := ch.client.(globals.ServerProber)
+		if !ok {
+			return fmt.Errorf("auth check method %s is not supported", m)
 		}
-		wg.Wait()
-		sort.Ints(availableServers)
-		sort.Ints(unavailableServers)
-		cfg.SetAvailableServers(availableServers)
-		logger.Debug("Available servers: %#v", availableServers)
-		cfg.SetUnavailableServers(unavailableServers)
-		logger.Debug("Unavailable servers: %#v", unavailableServers)
-		logger.Debug("End monitoring authentication check")
-		time.Sleep(interval)
+		return p.ProbeServer(ctx, idx)
+	}
+	r, err := ch.client.CheckAuthenticateUser(ctx, ch.cfg.AuthCheckUser(), ch.cfg.AuthCheckPass(), idx)
+	if err != nil {
+		return err
+	}
+	if !r {
+		return errors.New("unable authenticate monitoring user")
 	}
+	return nil
+}
 
+func (ch *Checker) report(idx int, ok bool) {
+	ch.m.Lock()
+	defer ch.m.Unlock()
+	if ok {
+		ch.successes[idx]++
+		ch.failures[idx] = 0
+		if !ch.up[idx] && ch.successes[idx] >= ch.cfg.AuthCheckRise() {
+			ch.up[idx] = true
+			ch.l.Infof("Server %s is available", ch.cfg.AuthServerName(idx))
+			ch.publish()
+		}
+		return
+	}
+	ch.failures[idx]++
+	ch.successes[idx] = 0
+	if ch.up[idx] && ch.failures[idx] >= ch.cfg.AuthCheckFall() {
+		ch.up[idx] = false
+		ch.l.Errorf("Server %s is unavailable", ch.cfg.AuthServerName(idx))
+		ch.publish()
+	}
+}
+
+// publish must be called with locked mutex
+func (ch *Checker) publish() {
+	availableServers := make([]int, 0, len(ch.up))
+	unavailableServers := make([]int, 0, len(ch.up))
+	for i, up := range ch.up {
+		if up {
+			availableServers = append(availableServers, i)
+		} else {
+			unavailableServers = append(unavailableServers, i)
+		}
+	}
+	ch.cfg.SetAvailableServers(availableServers)
+	ch.l.Debugf("Available servers: %#v", availableServers)
+	ch.cfg.SetUnavailableServers(unavailableServers)
+	ch.l.Debugf("Unavailable servers: %#v", unavailableServers)
+}
+
+func (ch *Checker) jitter() time.Duration {
+	j := ch.cfg.AuthCheckJitter()
+	if j <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(j)))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
 }
@@ -0,0 +1,236 @@
+package certs
+
+import (
+	"auth-service/internal/globals"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// modes of verification of client certificates
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+// Options configures tls of web server
+type Options struct {
+	Certificate string
+	PrivateKey  string
+	// CA certificates used to verify client certificates
+	ClientCA string
+	// none, optional or require. Default is require if ClientCA is set
+	ClientAuth string
+	// 1.0, 1.1, 1.2 or 1.3. Default is 1.2
+	MinVersion string
+	// names of cipher suites as in crypto/tls. Empty means defaults of Go
+	CipherSuites []string
+	// how often files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// ParseVersion returns tls version by name. Empty name means tls 1.2
+func ParseVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown tls version %s", name)
+}
+
+// ParseCipherSuites returns ids of secure cipher suites by names
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		id, ok := known[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", n)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseClientAuth returns mode of verification of client certificates
+func ParseClientAuth(name, clientCA string) (tls.ClientAuthType, error) {
+	if name == "" {
+		if clientCA == "" {
+			return tls.NoClientCert, nil
+		}
+		name = ClientAuthRequire
+	}
+	switch name {
+	case ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		if clientCA == "" {
+			return 0, errors.New("client ca must be set to verify client certificates")
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		if clientCA == "" {
+			return 0, errors.New("client ca must be set to verify client certificates")
+		}
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("unknown client auth mode %s", name)
+}
+
+// Reloader keeps certificate of server and client CA and reloads them when files change
+type Reloader struct {
+	o            Options
+	l            globals.AppLogger
+	minVersion   uint16
+	cipherSuites []uint16
+	clientAuth   tls.ClientAuthType
+	m            sync.RWMutex
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+	modTimes     map[string]time.Time
+}
+
+func New(o Options, l globals.AppLogger) (*Reloader, error) {
+	r := &Reloader{o: o, l: l}
+	var err error
+	if r.minVersion, err = ParseVersion(o.MinVersion); err != nil {
+		return nil, err
+	}
+	if r.cipherSuites, err = ParseCipherSuites(o.CipherSuites); err != nil {
+		return nil, err
+	}
+	if r.clientAuth, err = ParseClientAuth(o.ClientAuth, o.ClientCA); err != nil {
+		return nil, err
+	}
+	if r.o.ReloadInterval <= 0 {
+		r.o.ReloadInterval = defaultReloadInterval
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads files. On error current certificates are kept
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = fi.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.o.Certificate, r.o.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.o.ClientCA != "" {
+		pem, err := ioutil.ReadFile(r.o.ClientCA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.o.ClientCA)
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.o.Certificate, r.o.PrivateKey}
+	if r.o.ClientCA != "" {
+		files = append(files, r.o.ClientCA)
+	}
+	return files
+}
+
+// changed reports if any file was modified after the last load
+func (r *Reloader) changed() bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			// file is being replaced, try next time
+			continue
+		}
+		if !fi.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run checks files for changes and blocks until ctx is done
+func (r *Reloader) Run(ctx context.Context) {
+	t := time.NewTicker(r.o.ReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				r.l.Errorf("Unable to reload tls certificates, current certificates are kept. Error %s", err)
+				continue
+			}
+			r.l.Info("Tls certificates reloaded")
+		}
+	}
+}
+
+// TLSConfig returns config of web server. Certificates are taken from the last load on every handshake
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   r.minVersion,
+		CipherSuites: r.cipherSuites,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.m.RLock()
+			defer r.m.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.m.RLock()
+			defer r.m.RUnlock()
+			return &tls.Config{
+				MinVersion:   r.minVersion,
+				CipherSuites: r.cipherSuites,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   r.clientAuth,
+				ClientCAs:    r.clientCAs,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// Identity maps verified client certificate to caller. Certificate matches if any of set fields
+// is equal to common name of subject or one of subject alternative names
+type Identity struct {
+	Name       string
+	CommonName string
+	DNSName    string
+	URI        string
+	Email      string
+	Scopes     []string
+}
+
+func (id *Identity) matches(c *x509.Certificate) bool {
+	if id.CommonName != "" && id.CommonName == c.Subject.CommonName {
+		return true
+	}
+	if id.DNSName != "" && containsString(c.DNSNames, id.DNSName) {
+		return true
+	}
+	if id.Email != "" && containsString(c.EmailAddresses, id.Email) {
+		return true
+	}
+	if id.URI != "" {
+		for _, u := range c.URIs {
+			if u.String() == id.URI {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (id *Identity) hasScope(scope string) bool {
+	return containsString(id.Scopes, scope)
+}
+
+// Identities finds callers by client certificates. Nil value has no identities
+type Identities struct {
+	list []Identity
+	// api key is required in addition to certificate
+	requireApiKey bool
+}
+
+func NewIdentities(list []Identity, requireApiKey bool) *Identities {
+	return &Identities{list: list, requireApiKey: requireApiKey}
+}
+
+// RequireApiKey reports if X-Api-Key is checked even if client certificate identifies caller
+func (ids *Identities) RequireApiKey() bool {
+	return ids != nil && ids.requireApiKey
+}
+
+// Has reports if any identity has scope
+func (ids *Identities) Has(scope string) bool {
+	if ids == nil {
+		return false
+	}
+	for i := range ids.list {
+		if ids.list[i].hasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns name of identity with scope matching verified client certificate of connection
+func (ids *Identities) Match(cs *tls.ConnectionState, scope string) (string, bool) {
+	if ids == nil || cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	leaf := cs.VerifiedChains[0][0]
+	for i := range ids.list {
+		id := &ids.list[i]
+		if id.hasScope(scope) && id.matches(leaf) {
+			return id.Name, true
+		}
+	}
+	return "", false
+}
+
+func containsString(arr []string, v string) bool {
+	for _, x := range arr {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
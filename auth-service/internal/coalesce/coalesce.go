@@ -0,0 +1,173 @@
+package coalesce
+
+import (
+	"auth-service/internal/globals"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type call struct {
+	done chan struct{}
+	res  *globals.AuthResult
+	err  error
+	// exchange shared by waiters when the result is a challenge
+	next *call
+}
+
+// Group coalesces identical concurrent authentication requests onto one upstream exchange
+type Group struct {
+	m     sync.Mutex
+	calls map[string]*call
+	// key of hmac, so credentials can't be recovered from map keys
+	secret []byte
+}
+
+func NewGroup() *Group {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return &Group{calls: make(map[string]*call), secret: secret}
+}
+
+// Key returns key of request. Password is hashed with process-wide random secret
+func (g *Group) Key(user, pass, clientIP, state string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	for _, s := range []string{strings.ToLower(user), pass, clientIP, state} {
+		_, _ = mac.Write([]byte(s))
+		_, _ = mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrPanic is returned to waiters of request whose exchange panicked
+var ErrPanic = errors.New("coalesced authentication request panicked")
+
+// Do runs fn once for all concurrent calls with the same key. shared is true
+// if the caller got result of exchange started by another request.
+// Challenge of the first exchange is not shared, because its state can be used only once:
+// waiters of request answered with challenge run one follow-up exchange together and share
+// its result, challenge included. So N identical requests cause at most two exchanges
+func (g *Group) Do(key string, fn func() (*globals.AuthResult, error)) (res *globals.AuthResult, shared bool, err error) {
+	g.m.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.m.Unlock()
+		<-c.done
+		if isChallenge(c.err) {
+			return g.followUp(c, fn)
+		}
+		return c.res, true, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.m.Unlock()
+
+	g.run(c, fn, func() {
+		g.m.Lock()
+		delete(g.calls, key)
+		g.m.Unlock()
+	})
+	return c.res, false, c.err
+}
+
+// followUp runs fn once for waiters of lead
+func (g *Group) followUp(lead *call, fn func() (*globals.AuthResult, error)) (*globals.AuthResult, bool, error) {
+	g.m.Lock()
+	if c := lead.next; c != nil {
+		g.m.Unlock()
+		<-c.done
+		return c.res, true, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	lead.next = c
+	g.m.Unlock()
+
+	g.run(c, fn, nil)
+	return c.res, false, c.err
+}
+
+// run saves result of fn in c, calls release if it is set and wakes up waiters of c
+func (g *Group) run(c *call, fn func() (*globals.AuthResult, error), release func()) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			// waiters get error instead of empty result, the panic goes on in the caller
+			c.res, c.err = &globals.AuthResult{}, fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+		if release != nil {
+			release()
+		}
+		close(c.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	c.res, c.err = fn()
+}
+
+func isChallenge(err error) bool {
+	var ch *globals.AuthChallenge
+	return errors.As(err, &ch)
+}
+
+// UserLimiter limits number of requests of every user in sliding window
+type UserLimiter struct {
+	m        sync.Mutex
+	max      int
+	window   time.Duration
+	requests map[string][]time.Time
+}
+
+func NewUserLimiter(max int, window time.Duration) *UserLimiter {
+	return &UserLimiter{max: max, window: window, requests: make(map[string][]time.Time)}
+}
+
+// Allow records request of user and reports if it is within limit
+func (ul *UserLimiter) Allow(user string) bool {
+	user = strings.ToLower(user)
+	now := time.Now()
+	ul.m.Lock()
+	defer ul.m.Unlock()
+	reqs := ul.requests[user]
+	i := 0
+	for i < len(reqs) && now.Sub(reqs[i]) >= ul.window {
+		i++
+	}
+	reqs = reqs[i:]
+	if len(reqs) >= ul.max {
+		ul.requests[user] = reqs
+		return false
+	}
+	ul.requests[user] = append(reqs, now)
+	return true
+}
+
+// Run periodically removes users without requests in window until ctx is done
+func (ul *UserLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(ul.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ul.cleanup(now)
+		}
+	}
+}
+
+func (ul *UserLimiter) cleanup(now time.Time) {
+	ul.m.Lock()
+	defer ul.m.Unlock()
+	for u, reqs := range ul.requests {
+		if len(reqs) == 0 || now.Sub(reqs[len(reqs)-1]) >= ul.window {
+			delete(ul.requests, u)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package coalesce
+
+import (
+	"auth-service/internal/globals"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type result struct {
+	res    *globals.AuthResult
+	shared bool
+	err    error
+}
+
+// concurrent calls Do with key n times. The first call blocks in fn until all others wait for it.
+// answer returns result of i-th exchange. Returns results of calls and number of exchanges
+func concurrent(g *Group, n int, answer func(i int) (*globals.AuthResult, error)) ([]result, int) {
+	var exchanges int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func() (*globals.AuthResult, error) {
+		i := int(atomic.AddInt32(&exchanges, 1))
+		if i == 1 {
+			close(started)
+			<-release
+		}
+		return answer(i)
+	}
+	results := make([]result, n)
+	var done sync.WaitGroup
+	call := func(i int) {
+		defer done.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				results[i].err = errors.New("panic")
+			}
+		}()
+		res, shared, err := g.Do("key", fn)
+		results[i] = result{res, shared, err}
+	}
+	done.Add(n)
+	go call(0)
+	<-started
+	var waiting sync.WaitGroup
+	waiting.Add(n - 1)
+	for i := 1; i < n; i++ {
+		go func(i int) {
+			waiting.Done()
+			call(i)
+		}(i)
+	}
+	waiting.Wait()
+	// let the waiters block in Do
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+	return results, int(atomic.LoadInt32(&exchanges))
+}
+
+func challenge(i int) error {
+	return &globals.AuthChallenge{State: strconv.Itoa(i)}
+}
+
+func TestDo(t *testing.T) {
+	const n = 10
+	tests := []struct {
+		name   string
+		answer func(i int) (*globals.AuthResult, error)
+		// number of exchanges with servers
+		exchanges int
+		leader    error
+		waiters   error
+	}{
+		{
+			name: "accept is shared",
+			answer: func(i int) (*globals.AuthResult, error) {
+				return &globals.AuthResult{Accepted: true}, nil
+			},
+			exchanges: 1,
+		},
+		{
+			name: "reject is shared",
+			answer: func(i int) (*globals.AuthResult, error) {
+				return &globals.AuthResult{}, globals.ErrAuthenticationFailed
+			},
+			exchanges: 1,
+			leader:    globals.ErrAuthenticationFailed,
+			waiters:   globals.ErrAuthenticationFailed,
+		},
+		{
+			name: "waiters of challenge share one follow-up exchange",
+			answer: func(i int) (*globals.AuthResult, error) {
+				return &globals.AuthResult{}, challenge(i)
+			},
+			exchanges: 2,
+			leader:    challenge(1),
+			waiters:   challenge(2),
+		},
+		{
+			name: "follow-up exchange of challenge is accepted",
+			answer: func(i int) (*globals.AuthResult, error) {
+				if i == 1 {
+					return &globals.AuthResult{}, challenge(i)
+				}
+				return &globals.AuthResult{Accepted: true}, nil
+			},
+			exchanges: 2,
+			leader:    challenge(1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGroup()
+			results, exchanges := concurrent(g, n, tt.answer)
+			if exchanges != tt.exchanges {
+				t.Errorf("%d exchanges, want %d", exchanges, tt.exchanges)
+			}
+			shared := 0
+			for i, r := range results {
+				if r.shared {
+					shared++
+				}
+				want := tt.waiters
+				if i == 0 {
+					want = tt.leader
+				}
+				if errString(r.err) != errString(want) {
+					t.Errorf("call %d: error = %v, want %v", i, r.err, want)
+				}
+				if r.res == nil || r.res.Accepted != (want == nil) {
+					t.Errorf("call %d: result = %+v", i, r.res)
+				}
+			}
+			if shared != n-tt.exchanges {
+				t.Errorf("%d calls got shared result, want %d", shared, n-tt.exchanges)
+			}
+			if len(g.calls) != 0 {
+				t.Errorf("%d calls are left in group", len(g.calls))
+			}
+		})
+	}
+}
+
+func errString(err error) string {
+	var ch *globals.AuthChallenge
+	if errors.As(err, &ch) {
+		return "challenge " + ch.State
+	}
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestDoPanic(t *testing.T) {
+	g := NewGroup()
+	results, exchanges := concurrent(g, 5, func(i int) (*globals.AuthResult, error) {
+		panic("boom")
+	})
+	if exchanges != 1 {
+		t.Errorf("%d exchanges, want 1", exchanges)
+	}
+	if results[0].err == nil || results[0].err.Error() != "panic" {
+		t.Errorf("leader: error = %v, want panic to go on", results[0].err)
+	}
+	for i, r := range results[1:] {
+		if !errors.Is(r.err, ErrPanic) || !r.shared || r.res == nil {
+			t.Errorf("waiter %d: Do() = %+v, %v, %v, want %v", i+1, r.res, r.shared, r.err, ErrPanic)
+		}
+	}
+	// the key is released after panic
+	res, shared, err := g.Do("key", func() (*globals.AuthResult, error) {
+		return &globals.AuthResult{Accepted: true}, nil
+	})
+	if err != nil || shared || !res.Accepted {
+		t.Errorf("Do() after panic = %+v, %v, %v", res, shared, err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	g := NewGroup()
+	base := g.Key("user", "pass", "10.0.0.1", "")
+	tests := []struct {
+		name                  string
+		user, pass, ip, state string
+		same                  bool
+	}{
+		{"identical", "user", "pass", "10.0.0.1", "", true},
+		{"user name case", "USER", "pass", "10.0.0.1", "", true},
+		{"other password", "user", "pass2", "10.0.0.1", "", false},
+		{"other client ip", "user", "pass", "10.0.0.2", "", false},
+		{"response to challenge", "user", "pass", "10.0.0.1", "state", false},
+		{"fields are separated", "userp", "ass", "10.0.0.1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := g.Key(tt.user, tt.pass, tt.ip, tt.state) == base; same != tt.same {
+				t.Errorf("same key = %v, want %v", same, tt.same)
+			}
+		})
+	}
+	if NewGroup().Key("user", "pass", "10.0.0.1", "") == base {
+		t.Error("keys of groups are equal, want secret of every group")
+	}
+}
+
+// elapse moves requests recorded by ul back by d, as if d has passed
+func elapse(ul *UserLimiter, d time.Duration) {
+	ul.m.Lock()
+	defer ul.m.Unlock()
+	for _, reqs := range ul.requests {
+		for i := range reqs {
+			reqs[i] = reqs[i].Add(-d)
+		}
+	}
+}
+
+func TestUserLimiter(t *testing.T) {
+	ul := NewUserLimiter(2, time.Minute)
+	steps := []struct {
+		user string
+		// time passed before request
+		gap  time.Duration
+		want bool
+	}{
+		{"user", 0, true},
+		{"USER", time.Second, true},
+		{"user", time.Second, false},
+		{"other", 0, true},
+		{"user", 58 * time.Second, true},
+		{"user", time.Second, true},
+		{"user", time.Second, false},
+	}
+	for i, st := range steps {
+		elapse(ul, st.gap)
+		if got := ul.Allow(st.user); got != st.want {
+			t.Errorf("request %d of %s: Allow() = %v, want %v", i, st.user, got, st.want)
+		}
+	}
+}
@@ -1,26 +1,56 @@
 package config
 
 import (
+	"auth-service/internal/apikey"
+	"auth-service/internal/audit"
+	"auth-service/internal/certs"
 	"auth-service/internal/globals"
+	"auth-service/internal/offline"
+	"auth-service/internal/pool"
+	"auth-service/internal/secret"
+	"auth-service/internal/signing"
+	"auth-service/internal/syslog"
+	"auth-service/internal/throttle"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+const (
+	defaultChallengeTimeoutSec = 120
+	defaultAuthCheckTimeout    = 10 * time.Second
+	defaultMetricsPath         = "/metrics"
+)
+
+// decodeHook resolves references of secrets in addition to default hooks of viper
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+	secret.DecodeHook(),
+)
+
 type AppConfig struct {
-	l  globals.AppLogger
-	cf ConfigFile
+	l globals.AppLogger
+	// cf, pools and availableServers are replaced on reload and guarded by m
+	cf *ConfigFile
 	// LDAPSrv      []
 	// AuthProtocol       string `mapstructure:"auth_protocol" json:"auth_protocol"`
 	availableServers   []int
 	m                  sync.RWMutex
 	unavailableServers []int
 	m2                 sync.RWMutex
+	pool               *pool.Pool
+	acctPool           *pool.Pool
+	onFailure          func(idx int)
+	keys               *apikey.Store
+	identities         *certs.Identities
+	signing            *signing.Verifier
 }
 
 type ConfigFile struct {
@@ -30,55 +60,336 @@ type ConfigFile struct {
 	AuthRadius       *AuthRadius `mapstructure:"radius" json:"radius"`
 	AuthCheck        *AuthCheck  `mapstructure:"auth_check" json:"auth_check"`
 	AuthLDAP         *AuthLDAP   `mapstructure:"ldap" json:"ldap"`
+	Failover         Failover    `mapstructure:"failover" json:"failover"`
+	CircuitBreaker   Breaker     `mapstructure:"circuit_breaker" json:"circuit_breaker"`
+	Audit            Audit       `mapstructure:"audit" json:"audit"`
+	Throttle         Throttle    `mapstructure:"throttle" json:"throttle"`
+	Coalesce         Coalesce    `mapstructure:"coalesce" json:"coalesce"`
+	AuthCache        AuthCache   `mapstructure:"auth_cache" json:"auth_cache"`
+	Degraded         Degraded    `mapstructure:"degraded_mode" json:"degraded_mode"`
+}
+
+// Degraded configures local verification of users when none of authentication servers is available
+type Degraded struct {
+	Enable       bool         `mapstructure:"enable" json:"enable"`
+	StoreFile    string       `mapstructure:"store_file" json:"store_file"`
+	MaxAgeHours  int          `mapstructure:"max_age_hours" json:"max_age_hours"`
+	ExcludeUsers []string     `mapstructure:"exclude_users" json:"exclude_users"`
+	BreakGlass   []BreakGlass `mapstructure:"break_glass" json:"break_glass"`
+}
+
+type BreakGlass struct {
+	User string `mapstructure:"user" json:"user"`
+	// created with hash-password command
+	PasswordHash secret.Secret `mapstructure:"password_hash" json:"-"`
+}
+
+// AuthCache configures cache of successful authentications
+type AuthCache struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	TTLSec int  `mapstructure:"ttl_sec" json:"ttl_sec"`
+}
+
+// Coalesce configures coalescing of identical concurrent authentication requests
+type Coalesce struct {
+	Enable   bool     `mapstructure:"enable" json:"enable"`
+	MFALimit MFALimit `mapstructure:"mfa_limit" json:"mfa_limit"`
+}
+
+// MFALimit caps number of authentication requests of user sent to servers in window.
+// Every such request can trigger MFA push. Zero MaxRequests disables the limit
+type MFALimit struct {
+	MaxRequests int `mapstructure:"max_requests" json:"max_requests"`
+	WindowSec   int `mapstructure:"window_sec" json:"window_sec"`
+}
+
+// Throttle configures lockout of users and client ips after failed authentications
+type Throttle struct {
+	Enable   bool         `mapstructure:"enable" json:"enable"`
+	User     ThrottleRule `mapstructure:"user" json:"user"`
+	ClientIP ThrottleRule `mapstructure:"client_ip" json:"client_ip"`
+	UserIP   ThrottleRule `mapstructure:"user_ip" json:"user_ip"`
+}
+
+type ThrottleRule struct {
+	MaxFailures   int `mapstructure:"max_failures" json:"max_failures"`
+	WindowSec     int `mapstructure:"window_sec" json:"window_sec"`
+	LockoutSec    int `mapstructure:"lockout_sec" json:"lockout_sec"`
+	MaxLockoutSec int `mapstructure:"max_lockout_sec" json:"max_lockout_sec"`
+}
+
+func (r ThrottleRule) rule() throttle.Rule {
+	return throttle.Rule{
+		MaxFailures: r.MaxFailures,
+		Window:      time.Duration(r.WindowSec) * time.Second,
+		Lockout:     time.Duration(r.LockoutSec) * time.Second,
+		MaxLockout:  time.Duration(r.MaxLockoutSec) * time.Second,
+	}
+}
+
+// Audit configures log of authentication decisions. It is separate from application log
+type Audit struct {
+	Enable     bool   `mapstructure:"enable" json:"enable"`
+	File       string `mapstructure:"file" json:"file"`
+	MaxSizeMb  int    `mapstructure:"max_size_mb" json:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups" json:"max_backups"`
+	MaxAgeDays int    `mapstructure:"max_age_days" json:"max_age_days"`
+	Compress   bool   `mapstructure:"compress" json:"compress"`
+	Syslog     Syslog `mapstructure:"syslog" json:"syslog"`
+}
+
+// Syslog configures sending of messages to syslog server (RFC 5424)
+type Syslog struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// udp, tcp, tls or unix
+	Network  string `mapstructure:"network" json:"network"`
+	Address  string `mapstructure:"address" json:"address"`
+	CAFile   string `mapstructure:"ca_file" json:"ca_file"`
+	Facility string `mapstructure:"facility" json:"facility"`
+	AppName  string `mapstructure:"app_name" json:"app_name"`
+	// json, cef or leef. Used only for audit events
+	Format string `mapstructure:"format" json:"format"`
+}
+
+func (s *Syslog) options() syslog.Options {
+	return syslog.Options{
+		Network:  s.Network,
+		Address:  s.Address,
+		CAFile:   s.CAFile,
+		Facility: s.Facility,
+		AppName:  s.AppName,
+	}
+}
+
+// Breaker stops sending requests to server after consecutive transport failures
+type Breaker struct {
+	Enable           bool `mapstructure:"enable" json:"enable"`
+	FailureThreshold int  `mapstructure:"failure_threshold" json:"failure_threshold"`
+	CooldownSec      int  `mapstructure:"cooldown_sec" json:"cooldown_sec"`
+}
+
+// Failover limits retries of authentication request on other servers after transport errors
+type Failover struct {
+	MaxAttempts int `mapstructure:"max_attempts" json:"max_attempts"`
+	DeadlineSec int `mapstructure:"deadline_sec" json:"deadline_sec"`
 }
 
 type AuthCheck struct {
-	Enable      bool   `mapstructure:"enable" json:"enable"`
+	Enable      bool          `mapstructure:"enable" json:"enable"`
+	IntervalSec int           `mapstructure:"interval_sec" json:"interval_sec"`
+	JitterSec   int           `mapstructure:"jitter_sec" json:"jitter_sec"`
+	TimeoutSec  int           `mapstructure:"timeout_sec" json:"timeout_sec"`
+	Rise        int           `mapstructure:"rise" json:"rise"`
+	Fall        int           `mapstructure:"fall" json:"fall"`
+	Method      string        `mapstructure:"method" json:"method"`
+	CanaryUser  string        `mapstructure:"canary_user" json:"canary_user"`
+	User        string        `mapstructure:"user" json:"user"`
+	Pass        secret.Secret `mapstructure:"pass" json:"pass"`
+	// per server settings. Server is found by name
+	Servers []AuthCheckServer `mapstructure:"servers" json:"servers"`
+}
+
+type AuthCheckServer struct {
+	Name        string `mapstructure:"name" json:"name"`
 	IntervalSec int    `mapstructure:"interval_sec" json:"interval_sec"`
-	User        string `mapstructure:"user" json:"user"`
-	Pass        string `mapstructure:"pass" json:"pass"`
+	Method      string `mapstructure:"method" json:"method"`
 }
 
 type Server struct {
-	Address    string      `mapstructure:"address" json:"listen_address"`
-	Port       int         `mapstructure:"port" json:"port"`
-	AuthApiKey string      `mapstructure:"auth_api_key" json:"auth_api_key"`
-	HTTPS      HTTPSConfig `mapstructure:"https" json:"https"`
-	Monitoring Monitoring  `mapstructure:"monitoring" json:"monitoring"`
+	Address    string        `mapstructure:"address" json:"listen_address"`
+	Port       int           `mapstructure:"port" json:"port"`
+	AuthApiKey secret.Secret `mapstructure:"auth_api_key" json:"auth_api_key"`
+	HTTPS      HTTPSConfig   `mapstructure:"https" json:"https"`
+	Monitoring Monitoring    `mapstructure:"monitoring" json:"monitoring"`
+	Metrics    Metrics       `mapstructure:"metrics" json:"metrics"`
+	Admin      Admin         `mapstructure:"admin" json:"admin"`
+	ApiKeys    []ApiKey      `mapstructure:"api_keys" json:"api_keys"`
+	Signing    Signing       `mapstructure:"request_signing" json:"request_signing"`
+}
+
+// Signing configures HMAC signatures of requests which protect them from replay
+type Signing struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// allowed difference between timestamp of request and time of service. Default is 300
+	MaxSkewSec int `mapstructure:"max_skew_sec" json:"max_skew_sec"`
+	// requests without signature are accepted, used while callers are migrated
+	AllowUnsigned bool         `mapstructure:"allow_unsigned" json:"allow_unsigned"`
+	Keys          []SigningKey `mapstructure:"keys" json:"keys"`
+}
+
+// SigningKey is shared secret of caller
+type SigningKey struct {
+	Name   string        `mapstructure:"name" json:"name"`
+	Secret secret.Secret `mapstructure:"secret" json:"secret"`
+}
+
+// verifier returns nil if signing is disabled
+func (s *Signing) verifier() (*signing.Verifier, error) {
+	if !s.Enable {
+		return nil, nil
+	}
+	keys := make([]signing.Key, 0, len(s.Keys))
+	for _, k := range s.Keys {
+		keys = append(keys, signing.Key{Name: k.Name, Secret: k.Secret.Value()})
+	}
+	return signing.New(keys, time.Duration(s.MaxSkewSec)*time.Second, s.AllowUnsigned)
+}
+
+// ApiKey is named api key of caller stored as salted hash
+type ApiKey struct {
+	Name string `mapstructure:"name" json:"name"`
+	// created with hash-api-key command
+	Hash   secret.Secret `mapstructure:"hash" json:"hash"`
+	Scopes []string      `mapstructure:"scopes" json:"scopes"`
+	// RFC 3339 time. Empty means no limit
+	NotBefore string `mapstructure:"not_before" json:"not_before"`
+	Expires   string `mapstructure:"expires" json:"expires"`
+}
+
+// times returns validity period of key
+func (k *ApiKey) times() (notBefore, expires time.Time, err error) {
+	if k.NotBefore != "" {
+		if notBefore, err = time.Parse(time.RFC3339, k.NotBefore); err != nil {
+			return
+		}
+	}
+	if k.Expires != "" {
+		expires, err = time.Parse(time.RFC3339, k.Expires)
+	}
+	return
+}
+
+// apiKeys returns named keys and keys from api_key options. Empty api_key of auth and status
+// is used only if there are no named keys or client certificates with the scope,
+// so requests without key are accepted
+func (s *Server) apiKeys() ([]apikey.Key, error) {
+	keys := make([]apikey.Key, 0, len(s.ApiKeys)+4)
+	for _, k := range s.ApiKeys {
+		nb, exp, err := k.times()
+		if err != nil {
+			return nil, fmt.Errorf("api key %s: %w", k.Name, err)
+		}
+		keys = append(keys, apikey.Key{Name: k.Name, Hash: k.Hash.Value(), Scopes: k.Scopes, NotBefore: nb, Expires: exp})
+	}
+	named, err := apikey.New(keys)
+	if err != nil {
+		return nil, err
+	}
+	ids := s.HTTPS.identities()
+	legacy := []struct {
+		name, value, scope string
+		allowEmpty         bool
+	}{
+		{"auth_api_key", s.AuthApiKey.Value(), apikey.ScopeAuth, true},
+		{"status.api_key", s.Monitoring.ApiKey.Value(), apikey.ScopeStatus, true},
+		{"metrics.api_key", s.Metrics.ApiKey.Value(), apikey.ScopeMetrics, false},
+		{"admin.api_key", s.Admin.ApiKey.Value(), apikey.ScopeAdmin, false},
+	}
+	for _, l := range legacy {
+		if l.value != "" || (l.allowEmpty && !named.Has(l.scope) && (ids.RequireApiKey() || !ids.Has(l.scope))) {
+			keys = append(keys, apikey.Key{Name: l.name, Value: l.value, Scopes: []string{l.scope}})
+		}
+	}
+	return keys, nil
+}
+
+// Admin configures administrative api
+type Admin struct {
+	Enabled bool          `mapstructure:"enable" json:"enable"`
+	ApiKey  secret.Secret `mapstructure:"api_key" json:"api_key"`
 }
 
 type HTTPSConfig struct {
 	Enable      bool   `mapstructure:"enable" json:"enable"`
 	PrivateKey  string `mapstructure:"private_key" json:"private_key"`
 	Certificate string `mapstructure:"certificate" json:"certificate"`
+	// CA certificates used to verify client certificates
+	ClientCA string `mapstructure:"client_ca" json:"client_ca"`
+	// none, optional or require
+	ClientAuth   string   `mapstructure:"client_auth" json:"client_auth"`
+	MinVersion   string   `mapstructure:"min_version" json:"min_version"`
+	CipherSuites []string `mapstructure:"cipher_suites" json:"cipher_suites"`
+	// how often certificate files are checked for changes
+	ReloadIntervalSec int `mapstructure:"reload_interval_sec" json:"reload_interval_sec"`
+	// X-Api-Key is required in addition to client certificate
+	RequireApiKey bool         `mapstructure:"require_api_key" json:"require_api_key"`
+	Clients       []ClientCert `mapstructure:"clients" json:"clients"`
+}
+
+// ClientCert maps client certificate to caller. Certificate matches if any of set fields
+// is equal to common name or subject alternative name of certificate
+type ClientCert struct {
+	Name       string   `mapstructure:"name" json:"name"`
+	CommonName string   `mapstructure:"common_name" json:"common_name"`
+	DNSName    string   `mapstructure:"dns_name" json:"dns_name"`
+	URI        string   `mapstructure:"uri" json:"uri"`
+	Email      string   `mapstructure:"email" json:"email"`
+	Scopes     []string `mapstructure:"scopes" json:"scopes"`
+}
+
+func (h *HTTPSConfig) identities() *certs.Identities {
+	list := make([]certs.Identity, 0, len(h.Clients))
+	for _, c := range h.Clients {
+		list = append(list, certs.Identity{
+			Name:       c.Name,
+			CommonName: c.CommonName,
+			DNSName:    c.DNSName,
+			URI:        c.URI,
+			Email:      c.Email,
+			Scopes:     c.Scopes,
+		})
+	}
+	return certs.NewIdentities(list, h.RequireApiKey)
 }
 
 type Monitoring struct {
-	Enabled bool   `mapstructure:"enable" json:"enable"`
-	Path    string `mapstructure:"path" json:"path"`
-	ApiKey  string `mapstructure:"api_key" json:"api_key"`
+	Enabled bool          `mapstructure:"enable" json:"enable"`
+	Path    string        `mapstructure:"path" json:"path"`
+	ApiKey  secret.Secret `mapstructure:"api_key" json:"api_key"`
+}
+
+// Metrics configures prometheus metrics endpoint. If port is set, metrics are served
+// on separate http listener
+type Metrics struct {
+	Enabled       bool          `mapstructure:"enable" json:"enable"`
+	Path          string        `mapstructure:"path" json:"path"`
+	ApiKey        secret.Secret `mapstructure:"api_key" json:"api_key"`
+	ListenAddress string        `mapstructure:"listen_address" json:"listen_address"`
+	Port          int           `mapstructure:"port" json:"port"`
 }
 
 type Log struct {
-	File  string `mapstructure:"file" json:"file"`
-	Level string `mapstructure:"level" json:"level"`
+	File   string `mapstructure:"file" json:"file"`
+	Level  string `mapstructure:"level" json:"level"`
+	Syslog Syslog `mapstructure:"syslog" json:"syslog"`
 }
 
 type AuthRadius struct {
-	NASID          string      `mapstructure:"nas_id"`
-	NASIpV4AddrStr string      `mapstructure:"nas_ipv4_address"`
-	nasIpV4Addr    net.IP      `mapstructure:"-"`
-	NASPort        int         `mapstructure:"nas_port"`
-	RS             []RadiusSrv `mapstructure:"servers"`
+	NASID          string `mapstructure:"nas_id"`
+	NASIpV4AddrStr string `mapstructure:"nas_ipv4_address"`
+	nasIpV4Addr    net.IP `mapstructure:"-"`
+	NASPort        int    `mapstructure:"nas_port"`
+	// how long the state of Access-Challenge is kept waiting for user response
+	ChallengeTimeoutSec int              `mapstructure:"challenge_timeout_sec"`
+	Selection           string           `mapstructure:"selection"`
+	RS                  []RadiusSrv      `mapstructure:"servers"`
+	Accounting          RadiusAccounting `mapstructure:"accounting"`
+}
+
+type RadiusAccounting struct {
+	Enable    bool        `mapstructure:"enable"`
+	Selection string      `mapstructure:"selection"`
+	RS        []RadiusSrv `mapstructure:"servers"`
 }
 
 type AuthLDAP struct {
-	BindDN       string       `mapstructure:"bind_dn"`
-	Password     string       `mapstructure:"pass"`
-	SearchBase   string       `mapstructure:"search_base"`
-	SearchFilter string       `mapstructure:"search_filter"`
-	VerifyCert   bool         `mapstructure:"verify_cert"`
-	LS           []LDAPServer `mapstructure:"servers"`
+	BindDN       string        `mapstructure:"bind_dn"`
+	Password     secret.Secret `mapstructure:"pass"`
+	SearchBase   string        `mapstructure:"search_base"`
+	SearchFilter string        `mapstructure:"search_filter"`
+	VerifyCert   bool          `mapstructure:"verify_cert"`
+	Selection    string        `mapstructure:"selection"`
+	LS           []LDAPServer  `mapstructure:"servers"`
 }
 
 type LDAPServer struct {
@@ -87,6 +398,7 @@ type LDAPServer struct {
 	Port               int    `mapstructure:"port"`
 	UseSSL             bool   `mapstructure:"ssl"`
 	ResponseTimeoutSec int    `mapstructure:"response_timeout_sec"`
+	Weight             int    `mapstructure:"weight"`
 }
 
 func (ls LDAPServer) LDAPURL() string {
@@ -103,18 +415,27 @@ func (ls LDAPServer) GetUseSSL() bool {
 	return ls.UseSSL
 }
 
+func (ls LDAPServer) GetResponseTimeoutSec() int {
+	return ls.ResponseTimeoutSec
+}
+
+func (ls LDAPServer) GetName() string {
+	return ls.Name
+}
+
 type RadiusSrv struct {
-	Name               string `mapstructure:"name" json:"name"`
-	Address            string `mapstructure:"address" json:"address"`
-	Port               int    `mapstructure:"port" json:"port"`
-	Secret             string `mapstructure:"secret" json:"secret"`
-	Proto              string `mapstructure:"protocol" json:"protocol"`
-	ResponseTimeoutSec int    `mapstructure:"response_timeout_sec" json:"response_timeout_sec"`
+	Name               string        `mapstructure:"name" json:"name"`
+	Address            string        `mapstructure:"address" json:"address"`
+	Port               int           `mapstructure:"port" json:"port"`
+	Secret             secret.Secret `mapstructure:"secret" json:"secret"`
+	Proto              string        `mapstructure:"protocol" json:"protocol"`
+	ResponseTimeoutSec int           `mapstructure:"response_timeout_sec" json:"response_timeout_sec"`
+	Weight             int           `mapstructure:"weight" json:"weight"`
 }
 
 func NewConfig() *AppConfig {
 	return &AppConfig{
-		cf: ConfigFile{
+		cf: &ConfigFile{
 			// Srv: Server{},
 			// L:   Log{},
 			// AuthCheck:  AuthCheck{},
@@ -127,87 +448,182 @@ func NewConfig() *AppConfig {
 }
 
 func (cfg *AppConfig) LoadConfig(config_file string) error {
-	var err error
-	viper.SetConfigFile(config_file)
-	err = viper.ReadInConfig() // Find and read the config file
-	if err != nil {            // Handle errors reading the config file
-		panic(fmt.Errorf("Fatal error config file: %s \n", err))
+	next, err := parseConfig(config_file)
+	if err != nil {
+		return err
+	}
+	cfg.m.Lock()
+	defer cfg.m.Unlock()
+	cfg.cf, cfg.pool, cfg.acctPool = next.cf, next.pool, next.acctPool
+	cfg.keys, cfg.identities, cfg.signing = next.keys, next.identities, next.signing
+	return nil
+}
+
+// parseConfig reads and validates config file. Returned config is not used by the service yet
+func parseConfig(config_file string) (*AppConfig, error) {
+	cfg := &AppConfig{cf: &ConfigFile{}}
+	v := viper.New()
+	v.SetConfigFile(config_file)
+	err := v.ReadInConfig() // Find and read the config file
+	if err != nil {         // Handle errors reading the config file
+		return nil, fmt.Errorf("unable to read config file: %s", err)
 	}
 
 	var metadata mapstructure.Metadata
 	var setDecoderOptsStrict = func(c *mapstructure.DecoderConfig) {
 		c.ErrorUnused = true
 		c.Metadata = &metadata
+		c.DecodeHook = decodeHook
 	}
 
 	var setDecoderOptsRelaxed = func(c *mapstructure.DecoderConfig) {
 		c.ErrorUnused = false
 		c.Metadata = &metadata
+		c.DecodeHook = decodeHook
 	}
 	var srv Server
-	err = viper.UnmarshalKey("web_server", &srv, setDecoderOptsRelaxed)
+	err = v.UnmarshalKey("web_server", &srv, setDecoderOptsRelaxed)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var monitoring_enabled bool
-	err = viper.UnmarshalKey("web_server.status.enable", &monitoring_enabled, setDecoderOptsStrict)
+	err = v.UnmarshalKey("web_server.status.enable", &monitoring_enabled, setDecoderOptsStrict)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var path, apiKey string
+	var path string
+	var apiKey secret.Secret
 	if monitoring_enabled {
-		err = viper.UnmarshalKey("web_server.status.path", &path, setDecoderOptsStrict)
+		err = v.UnmarshalKey("web_server.status.path", &path, setDecoderOptsStrict)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = viper.UnmarshalKey("web_server.status.api_key", &apiKey, setDecoderOptsStrict)
+		err = v.UnmarshalKey("web_server.status.api_key", &apiKey, setDecoderOptsStrict)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		srv.Monitoring.Enabled = true
 		srv.Monitoring.Path = path
 		srv.Monitoring.ApiKey = apiKey
 	}
 
+	if srv.Metrics.Enabled && srv.Metrics.Path == "" {
+		srv.Metrics.Path = defaultMetricsPath
+	}
+
 	cfg.cf.Srv = srv
 	var l Log
-	err = viper.UnmarshalKey("log", &l, setDecoderOptsStrict)
+	err = v.UnmarshalKey("log", &l, setDecoderOptsStrict)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	cfg.cf.L = l
 
+	var au Audit
+	err = v.UnmarshalKey("audit", &au, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.Audit = au
+
+	var th Throttle
+	err = v.UnmarshalKey("throttle", &th, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.Throttle = th
+
+	var co Coalesce
+	err = v.UnmarshalKey("coalesce", &co, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.Coalesce = co
+
+	var ach AuthCache
+	err = v.UnmarshalKey("auth_cache", &ach, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.AuthCache = ach
+
+	var dg Degraded
+	err = v.UnmarshalKey("degraded_mode", &dg, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.Degraded = dg
+
 	var ac AuthCheck
-	err = viper.UnmarshalKey("auth_provider.auth_check", &ac, setDecoderOptsStrict)
+	err = v.UnmarshalKey("auth_provider.auth_check", &ac, setDecoderOptsStrict)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	cfg.cf.AuthCheck = &ac
 
-	cfg.cf.AuthProviderType = viper.GetString("auth_provider.type")
+	var fo Failover
+	err = v.UnmarshalKey("auth_provider.failover", &fo, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.Failover = fo
+
+	var br Breaker
+	err = v.UnmarshalKey("auth_provider.circuit_breaker", &br, setDecoderOptsStrict)
+	if err != nil {
+		return nil, err
+	}
+	cfg.cf.CircuitBreaker = br
+
+	cfg.cf.AuthProviderType = v.GetString("auth_provider.type")
 
 	switch cfg.cf.AuthProviderType {
 	case "radius":
-		return cfg.loadRadiusSettings()
+		err = cfg.loadRadiusSettings(v)
 	case "ldap":
-		return cfg.loadLDAPSettings()
-	default:
-		return fmt.Errorf("unsupported auth provider type")
+		err = cfg.loadLDAPSettings(v)
 	}
+	if err != nil {
+		return nil, err
+	}
+	if problems := cfg.cf.validate(); hasErrors(problems) {
+		return nil, &ValidationError{Problems: problems}
+	}
+	cfg.pool, err = cfg.newPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg.acctPool, err = cfg.newAccountingPool()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cfg.cf.Srv.apiKeys()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.keys, err = apikey.New(keys); err != nil {
+		return nil, err
+	}
+	cfg.identities = cfg.cf.Srv.HTTPS.identities()
+	if cfg.signing, err = cfg.cf.Srv.Signing.verifier(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
-func (cfg *AppConfig) loadLDAPSettings() error {
+func (cfg *AppConfig) loadLDAPSettings(v *viper.Viper) error {
 	var metadata mapstructure.Metadata
 	var setDecoderOptsStrict = func(c *mapstructure.DecoderConfig) {
 		c.ErrorUnused = true
 		c.Metadata = &metadata
+		c.DecodeHook = decodeHook
 	}
 	authL := AuthLDAP{
 		LS: make([]LDAPServer, 0),
 	}
 
-	err := viper.UnmarshalKey("auth_provider.ldap", &authL, setDecoderOptsStrict)
+	err := v.UnmarshalKey("auth_provider.ldap", &authL, setDecoderOptsStrict)
 	if err != nil {
 		return err
 	}
@@ -215,7 +631,7 @@ func (cfg *AppConfig) loadLDAPSettings() error {
 	return nil
 }
 
-func (cfg *AppConfig) loadRadiusSettings() error {
+func (cfg *AppConfig) loadRadiusSettings(v *viper.Viper) error {
 	var metadata mapstructure.Metadata
 	authr := AuthRadius{
 		RS: make([]RadiusSrv, 0),
@@ -223,136 +639,483 @@ func (cfg *AppConfig) loadRadiusSettings() error {
 	var setDecoderOptsStrict = func(c *mapstructure.DecoderConfig) {
 		c.ErrorUnused = true
 		c.Metadata = &metadata
+		c.DecodeHook = decodeHook
 	}
-	err := viper.UnmarshalKey("auth_provider.radius", &authr, setDecoderOptsStrict)
+	err := v.UnmarshalKey("auth_provider.radius", &authr, setDecoderOptsStrict)
 	if err != nil {
 		return err
 	}
 	cfg.cf.AuthRadius = &authr
 
 	cfg.cf.AuthRadius.nasIpV4Addr = net.ParseIP(cfg.cf.AuthRadius.NASIpV4AddrStr)
+
 	return nil
 }
 
+// newPool creates pool of authentication servers of validated config
+func (cfg *AppConfig) newPool() (*pool.Pool, error) {
+	var selection string
+	var servers []pool.ServerOptions
+	timeout := 0
+	switch cfg.cf.AuthProviderType {
+	case globals.AuthProviderRadius:
+		return newRadiusPool(cfg.cf.AuthRadius.Selection, cfg.cf.AuthRadius.RS, cfg.cf.CircuitBreaker)
+	case globals.AuthProviderLDAP:
+		selection = cfg.cf.AuthLDAP.Selection
+		for _, s := range cfg.cf.AuthLDAP.LS {
+			servers = append(servers, pool.ServerOptions{Name: s.Name, Weight: s.Weight})
+			if s.ResponseTimeoutSec > timeout {
+				timeout = s.ResponseTimeoutSec
+			}
+		}
+	}
+	return pool.New(selection, servers, breakerConfig(cfg.cf.CircuitBreaker, timeout))
+}
+
+// newAccountingPool creates pool of radius accounting servers. Pool is empty if accounting is disabled
+func (cfg *AppConfig) newAccountingPool() (*pool.Pool, error) {
+	if cfg.cf.AuthProviderType != globals.AuthProviderRadius || !cfg.cf.AuthRadius.Accounting.Enable {
+		return pool.New("", nil, breakerConfig(cfg.cf.CircuitBreaker, 0))
+	}
+	acct := cfg.cf.AuthRadius.Accounting
+	return newRadiusPool(acct.Selection, acct.RS, cfg.cf.CircuitBreaker)
+}
+
+func newRadiusPool(selection string, rs []RadiusSrv, br Breaker) (*pool.Pool, error) {
+	servers := make([]pool.ServerOptions, 0, len(rs))
+	timeout := 0
+	for _, s := range rs {
+		servers = append(servers, pool.ServerOptions{Name: s.Name, Weight: s.Weight})
+		if s.ResponseTimeoutSec > timeout {
+			timeout = s.ResponseTimeoutSec
+		}
+	}
+	return pool.New(selection, servers, breakerConfig(br, timeout))
+}
+
+// breakerConfig returns settings of circuit breakers. Probe request of half open breaker
+// is considered lost after the longest response timeout of servers
+func breakerConfig(br Breaker, timeoutSec int) pool.BreakerConfig {
+	return pool.BreakerConfig{
+		Enable:       br.Enable,
+		Threshold:    br.FailureThreshold,
+		Cooldown:     time.Duration(br.CooldownSec) * time.Second,
+		ProbeTimeout: time.Duration(timeoutSec) * time.Second,
+	}
+}
+
+// file returns current config file. Returned value is never modified, reload replaces it
+func (cfg *AppConfig) file() *ConfigFile {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	return cfg.cf
+}
+
+// snapshot returns consistent view of config, pool and available servers
+func (cfg *AppConfig) snapshot() (*AppConfig, *pool.Pool, []int) {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	available := make([]int, len(cfg.availableServers))
+	copy(available, cfg.availableServers)
+	return &AppConfig{cf: cfg.cf, l: cfg.l}, cfg.pool, available
+}
+
+func (cfg *AppConfig) IsThrottleEnabled() bool {
+	return cfg.file().Throttle.Enable
+}
+
+func (cfg *AppConfig) ThrottleConfig() throttle.Config {
+	return throttle.Config{
+		User:     cfg.file().Throttle.User.rule(),
+		ClientIP: cfg.file().Throttle.ClientIP.rule(),
+		UserIP:   cfg.file().Throttle.UserIP.rule(),
+	}
+}
+
+func (cfg *AppConfig) IsCoalesceEnabled() bool {
+	return cfg.file().Coalesce.Enable
+}
+
+// MFALimit returns max number of authentication requests of user sent to servers in window.
+// Zero means no limit
+func (cfg *AppConfig) MFALimit() (int, time.Duration) {
+	l := cfg.file().Coalesce.MFALimit
+	return l.MaxRequests, time.Duration(l.WindowSec) * time.Second
+}
+
+func (cfg *AppConfig) IsAuthCacheEnabled() bool {
+	return cfg.file().AuthCache.Enable
+}
+
+func (cfg *AppConfig) AuthCacheTTL() time.Duration {
+	return time.Duration(cfg.file().AuthCache.TTLSec) * time.Second
+}
+
+func (cfg *AppConfig) IsDegradedModeEnabled() bool {
+	return cfg.file().Degraded.Enable
+}
+
+func (cfg *AppConfig) DegradedModeConfig() offline.Config {
+	dg := cfg.file().Degraded
+	bg := make(map[string]string, len(dg.BreakGlass))
+	for _, b := range dg.BreakGlass {
+		bg[b.User] = b.PasswordHash.Value()
+	}
+	return offline.Config{
+		StoreFile:    dg.StoreFile,
+		MaxAge:       time.Duration(dg.MaxAgeHours) * time.Hour,
+		ExcludeUsers: dg.ExcludeUsers,
+		BreakGlass:   bg,
+	}
+}
+
+func (cfg *AppConfig) IsAuditEnabled() bool {
+	return cfg.file().Audit.Enable
+}
+
+func (cfg *AppConfig) AuditFileOptions() audit.FileOptions {
+	return audit.FileOptions{
+		File:       cfg.file().Audit.File,
+		MaxSizeMb:  cfg.file().Audit.MaxSizeMb,
+		MaxBackups: cfg.file().Audit.MaxBackups,
+		MaxAgeDays: cfg.file().Audit.MaxAgeDays,
+		Compress:   cfg.file().Audit.Compress,
+	}
+}
+
+// AuditSyslog returns options of audit syslog sink and format of events. ok is false if the sink is disabled
+func (cfg *AppConfig) AuditSyslog() (o syslog.Options, format string, ok bool) {
+	s := cfg.file().Audit.Syslog
+	return s.options(), s.Format, s.Enable
+}
+
+// LogSyslog returns options of syslog output of application log. ok is false if it is disabled
+func (cfg *AppConfig) LogSyslog() (o syslog.Options, ok bool) {
+	s := cfg.file().L.Syslog
+	return s.options(), s.Enable
+}
+
 func (cfg *AppConfig) LogConfig() (file, level string) {
-	return cfg.cf.L.File, cfg.cf.L.Level
+	return cfg.file().L.File, cfg.file().L.Level
 }
 
 func (cfg *AppConfig) SetAppLogger(l globals.AppLogger) {
+	cfg.m.Lock()
+	defer cfg.m.Unlock()
 	cfg.l = l
+	if cfg.pool != nil {
+		cfg.pool.SetLogger(l)
+	}
+	if cfg.acctPool != nil {
+		cfg.acctPool.SetLogger(l)
+	}
 }
 func (cfg *AppConfig) AppLogger() globals.AppLogger {
 	return cfg.l
 }
 
 func (c *AppConfig) WebSrvConfig() globals.WebSrvConfigProvider {
-	return &c.cf.Srv
+	return &c.file().Srv
 }
 
 func (c *AppConfig) AuthCheckUser() string {
-	return c.cf.AuthCheck.User
+	return c.file().AuthCheck.User
 }
 
 func (c *AppConfig) AuthCheckPass() string {
-	return c.cf.AuthCheck.Pass
+	return c.file().AuthCheck.Pass.Value()
+}
+
+// AuthCheckInterval returns interval between checks of server i
+func (c *AppConfig) AuthCheckInterval(i int) time.Duration {
+	sec := c.file().AuthCheck.IntervalSec
+	if acs := c.authCheckServer(i); acs != nil && acs.IntervalSec > 0 {
+		sec = acs.IntervalSec
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func (c *AppConfig) authCheckServer(i int) *AuthCheckServer {
+	name := c.AuthServerName(i)
+	for k := range c.file().AuthCheck.Servers {
+		if c.file().AuthCheck.Servers[k].Name == name {
+			return &c.file().AuthCheck.Servers[k]
+		}
+	}
+	return nil
+}
+
+// AuthCheckMethod returns method of check of server i. Default method is credentials
+func (c *AppConfig) AuthCheckMethod(i int) string {
+	var sm string
+	if acs := c.authCheckServer(i); acs != nil {
+		sm = acs.Method
+	}
+	return checkMethod(c.file().AuthCheck.Method, sm)
+}
+
+// AuthCheckCanaryUser returns login searched by ldap_bind check
+func (c *AppConfig) AuthCheckCanaryUser() string {
+	return c.file().AuthCheck.CanaryUser
+}
+
+// AuthCheckJitter returns max random delay added to check interval
+func (c *AppConfig) AuthCheckJitter() time.Duration {
+	return time.Duration(c.file().AuthCheck.JitterSec) * time.Second
 }
-func (c *AppConfig) AuthCheckInterval() int {
-	return c.cf.AuthCheck.IntervalSec
+
+// AuthCheckTimeout returns time limit of one check. Default value is 10 seconds
+func (c *AppConfig) AuthCheckTimeout() time.Duration {
+	if c.file().AuthCheck.TimeoutSec <= 0 {
+		return defaultAuthCheckTimeout
+	}
+	return time.Duration(c.file().AuthCheck.TimeoutSec) * time.Second
+}
+
+// AuthCheckRise returns number of consecutive successful checks required to mark server available
+func (c *AppConfig) AuthCheckRise() int {
+	if c.file().AuthCheck.Rise <= 0 {
+		return 1
+	}
+	return c.file().AuthCheck.Rise
+}
+
+// AuthCheckFall returns number of consecutive failed checks required to mark server unavailable
+func (c *AppConfig) AuthCheckFall() int {
+	if c.file().AuthCheck.Fall <= 0 {
+		return 1
+	}
+	return c.file().AuthCheck.Fall
+}
+
+// SetServerFailureListener sets function called on every transport failure of user authentication request
+func (c *AppConfig) SetServerFailureListener(f func(idx int)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.onFailure = f
+	c.pool.SetFailureListener(f)
 }
 
 func (c *AppConfig) AuthProviderType() string {
-	return c.cf.AuthProviderType
+	return c.file().AuthProviderType
 }
 
 func (cfg *AppConfig) RadiusServer(i int) (globals.RadiusProvider, error) {
-	if i >= len(cfg.cf.AuthRadius.RS) {
+	if i >= len(cfg.file().AuthRadius.RS) {
 		return nil, errors.New("requested value exceeds number of radius servers")
 	}
-	return &cfg.cf.AuthRadius.RS[i], nil
+	return &cfg.file().AuthRadius.RS[i], nil
 }
 
-func (cfg *AppConfig) LDAPAuthServer(i int) (globals.LDAPServerProvider, error) {
-	if i >= len(cfg.cf.AuthLDAP.LS) {
-		return nil, errors.New("requested value exceeds number of ldap servers")
-	}
-	return &cfg.cf.AuthLDAP.LS[i], nil
+func (cfg *AppConfig) IsAccountingEnabled() bool {
+	return cfg.file().AuthProviderType == globals.AuthProviderRadius && cfg.file().AuthRadius.Accounting.Enable
+}
+
+func (cfg *AppConfig) NumAccountingServers() int {
+	return len(cfg.file().AuthRadius.Accounting.RS)
 }
 
-func (cfg *AppConfig) GetAvailableAuthLDAPServer() (globals.LDAPServerProvider, error) {
+// GetAvailableAccountingServer chooses accounting server for record of session. Servers with open
+// circuit breaker and indexes from exclude are not chosen. Returned function must be called
+// when the request to server is finished
+func (cfg *AppConfig) GetAvailableAccountingServer(session string, exclude []int) (int, globals.RadiusProvider, func(error), error) {
 	cfg.m.RLock()
 	defer cfg.m.RUnlock()
-	if len(cfg.availableServers) == 0 {
-		return nil, errors.New("No servers available for authentication")
+	if cfg.cf.AuthRadius == nil || !cfg.cf.AuthRadius.Accounting.Enable {
+		return 0, nil, nil, errors.New("radius accounting is disabled")
 	}
+	rs := cfg.cf.AuthRadius.Accounting.RS
+	available := make([]int, len(rs))
+	for i := range rs {
+		available[i] = i
+	}
+	idx, err := cfg.acctPool.Pick(available, session, exclude)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return idx, &rs[idx], cfg.acctPool.Acquire(idx), nil
+}
+
+func (cfg *AppConfig) LDAPAuthServer(i int) (globals.LDAPServerProvider, error) {
+	if i >= len(cfg.file().AuthLDAP.LS) {
+		return nil, errors.New("requested value exceeds number of ldap servers")
+	}
+	return &cfg.file().AuthLDAP.LS[i], nil
+}
 
-	s, err := cfg.LDAPAuthServer(cfg.availableServers[0])
+// GetAvailableAuthLDAPServer chooses one of available servers for authentication of user.
+// Returned function must be called when the request to server is finished
+// Servers with indexes from exclude are not chosen
+func (cfg *AppConfig) GetAvailableAuthLDAPServer(user string, exclude []int) (int, globals.LDAPServerProvider, func(error), error) {
+	idx, cf, p, err := cfg.pickAuthServer(user, exclude)
 	if err != nil {
-		return nil, err
+		return 0, nil, nil, err
+	}
+	if idx >= len(cf.AuthLDAP.LS) {
+		return 0, nil, nil, errors.New("requested value exceeds number of ldap servers")
 	}
-	return s, nil
+	return idx, &cf.AuthLDAP.LS[idx], p.Acquire(idx), nil
+}
+
+// pickAuthServer returns index of chosen server with config and pool it belongs to
+func (cfg *AppConfig) pickAuthServer(user string, exclude []int) (int, *ConfigFile, *pool.Pool, error) {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	idx, err := cfg.pool.Pick(cfg.availableServers, user, exclude)
+	return idx, cfg.cf, cfg.pool, err
 }
 
 func (cfg *AppConfig) GetLDAPVerifyCert() bool {
-	return cfg.cf.AuthLDAP.VerifyCert
+	return cfg.file().AuthLDAP.VerifyCert
 }
 func (cfg *AppConfig) GetSearchBase() string {
-	return cfg.cf.AuthLDAP.SearchBase
+	return cfg.file().AuthLDAP.SearchBase
 }
 func (cfg *AppConfig) GetSearchFilter() string {
-	return cfg.cf.AuthLDAP.SearchFilter
+	return cfg.file().AuthLDAP.SearchFilter
 
 }
 func (cfg *AppConfig) GetBindUserDN() string {
-	return cfg.cf.AuthLDAP.BindDN
+	return cfg.file().AuthLDAP.BindDN
 }
 func (cfg *AppConfig) GetPassword() string {
-	return cfg.cf.AuthLDAP.Password
+	return cfg.file().AuthLDAP.Password.Value()
 }
 
 func (c *AppConfig) AuthServerName(i int) string {
-	if c.cf.AuthProviderType == globals.AuthProviderRadius {
-		return c.cf.AuthRadius.RS[i].Name
+	if c.file().AuthProviderType == globals.AuthProviderRadius {
+		return c.file().AuthRadius.RS[i].Name
 	}
-	if c.cf.AuthProviderType == globals.AuthProviderLDAP {
-		return c.cf.AuthLDAP.LS[i].Name
+	if c.file().AuthProviderType == globals.AuthProviderLDAP {
+		return c.file().AuthLDAP.LS[i].Name
+	}
+	return ""
+}
+
+// AuthServerAddress returns address of server i in form host:port or ldap url
+func (c *AppConfig) AuthServerAddress(i int) string {
+	if c.file().AuthProviderType == globals.AuthProviderRadius {
+		rs := c.file().AuthRadius.RS[i]
+		return rs.Address + ":" + strconv.Itoa(rs.Port)
+	}
+	if c.file().AuthProviderType == globals.AuthProviderLDAP {
+		return c.file().AuthLDAP.LS[i].LDAPURL()
 	}
 	return ""
 }
 
 func (c *AppConfig) NumAuthServers() int {
-	if c.cf.AuthProviderType == globals.AuthProviderRadius {
-		return len(c.cf.AuthRadius.RS)
+	if c.file().AuthProviderType == globals.AuthProviderRadius {
+		return len(c.file().AuthRadius.RS)
 	}
-	if c.cf.AuthProviderType == globals.AuthProviderLDAP {
-		return len(c.cf.AuthLDAP.LS)
+	if c.file().AuthProviderType == globals.AuthProviderLDAP {
+		return len(c.file().AuthLDAP.LS)
 	}
 	return 0
 }
 
 func (c *AppConfig) IsMonitoringEnabled() bool {
-	return c.cf.Srv.Monitoring.Enabled
+	return c.file().Srv.Monitoring.Enabled
 }
 
+// AuthServersStatus returns status of service and every authentication server.
+// Servers with open circuit breaker are counted as unavailable
 func (c *AppConfig) AuthServersStatus() *globals.MonitoringStatusResponse {
-	if len(c.availableServers) == 0 {
-		return &globals.MonitoringStatusResponse{
-			ID:   3,
-			Text: globals.StatusText(3),
-			Msg:  "None of authentication servers available",
+	snap, p, availableServers := c.snapshot()
+	available := p.Usable(availableServers)
+	num := snap.NumAuthServers()
+
+	servers := make([]globals.ServerStatus, 0, num)
+	for i := 0; i < num; i++ {
+		ss := p.Server(i).Status()
+		ss.Name = snap.AuthServerName(i)
+		ss.Address = snap.AuthServerAddress(i)
+		switch {
+		case !containsInt(availableServers, i):
+			ss.State = globals.ServerUnavailable
+		case !containsInt(available, i):
+			ss.State = globals.ServerCircuitOpen
+		default:
+			ss.State = globals.ServerAvailable
 		}
+		servers = append(servers, *ss)
+	}
+
+	resp := &globals.MonitoringStatusResponse{
+		Provider:          snap.AuthProviderType(),
+		Servers:           servers,
+		AccountingServers: c.accountingServersStatus(),
+	}
+	switch {
+	case len(available) == 0:
+		resp.ID = globals.StatusError
+		resp.Msg = "None of authentication servers available"
+	case len(available) != num:
+		resp.ID = globals.StatusWarn
+		resp.Msg = fmt.Sprintf("%d of %d authentication servers available", len(available), num)
+	default:
+		resp.ID = globals.StatusOk
+	}
+	resp.Text = globals.StatusText(resp.ID)
+	return resp
+}
+
+// accountingServersStatus returns status of radius accounting servers or nil if accounting is disabled
+func (c *AppConfig) accountingServersStatus() []globals.ServerStatus {
+	c.m.RLock()
+	cf, p := c.cf, c.acctPool
+	c.m.RUnlock()
+	if cf.AuthProviderType != globals.AuthProviderRadius || !cf.AuthRadius.Accounting.Enable {
+		return nil
 	}
-	if len(c.unavailableServers) != 0 {
-		return &globals.MonitoringStatusResponse{
-			ID:   2,
-			Text: globals.StatusText(2),
-			Msg:  fmt.Sprintf("%d of %d authentication servers available", len(c.availableServers), len(c.cf.AuthRadius.RS)),
+	rs := cf.AuthRadius.Accounting.RS
+	all := make([]int, len(rs))
+	for i := range rs {
+		all[i] = i
+	}
+	usable := p.Usable(all)
+	servers := make([]globals.ServerStatus, 0, len(rs))
+	for i, s := range rs {
+		ss := p.Server(i).Status()
+		ss.Name = s.Name
+		ss.Address = s.Address + ":" + strconv.Itoa(s.Port)
+		ss.State = globals.ServerAvailable
+		if !containsInt(usable, i) {
+			ss.State = globals.ServerCircuitOpen
 		}
+		servers = append(servers, *ss)
 	}
-	return &globals.MonitoringStatusResponse{
-		ID:   1,
-		Text: globals.StatusText(1),
-		Msg:  "",
+	return servers
+}
+
+// ServersState returns number of available and unavailable authentication servers.
+// Servers with open circuit breaker are counted as unavailable
+func (c *AppConfig) ServersState() (int, int) {
+	snap, p, availableServers := c.snapshot()
+	available := len(p.Usable(availableServers))
+	return available, snap.NumAuthServers() - available
+}
+
+// ReportCheck records result of periodic check of server i
+func (c *AppConfig) ReportCheck(i int, err error) {
+	_, p, _ := c.snapshot()
+	p.ReportCheck(i, err)
+}
+
+// IsAuthServerAvailable returns true if server i is in list of available servers
+func (c *AppConfig) IsAuthServerAvailable(i int) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return containsInt(c.availableServers, i)
+}
+
+func containsInt(arr []int, v int) bool {
+	for _, x := range arr {
+		if x == v {
+			return true
+		}
 	}
+	return false
 }
 
 func (c *AppConfig) SetAvailableServers(v []int) {
@@ -371,19 +1134,50 @@ func (c *AppConfig) SetUnavailableServers(v []int) {
 	c.unavailableServers = v2
 }
 
-func (cfg *AppConfig) GetAvailableRadiusAuthServer() (globals.RadiusProvider, error) {
-	//TODO: implement algorithm of choice server
+// GetAvailableRadiusAuthServer chooses one of available servers for authentication of user.
+// Returned function must be called when the request to server is finished
+// Servers with indexes from exclude are not chosen
+func (cfg *AppConfig) GetAvailableRadiusAuthServer(user string, exclude []int) (int, globals.RadiusProvider, func(error), error) {
+	idx, cf, p, err := cfg.pickAuthServer(user, exclude)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if idx >= len(cf.AuthRadius.RS) {
+		return 0, nil, nil, errors.New("requested value exceeds number of radius servers")
+	}
+	return idx, &cf.AuthRadius.RS[idx], p.Acquire(idx), nil
+}
+
+// ChallengeServer returns radius server name with address addr of current config and marks start
+// of response to challenge sent to it. Returned function must be called when the request is finished.
+// ok is false if the server was removed or moved to another address by reload
+func (cfg *AppConfig) ChallengeServer(name, addr string) (srv globals.RadiusProvider, done func(error), ok bool) {
 	cfg.m.RLock()
 	defer cfg.m.RUnlock()
-	if len(cfg.availableServers) == 0 {
-		return nil, errors.New("No servers available for authentication")
+	if cfg.cf.AuthRadius == nil {
+		return nil, nil, false
+	}
+	for i := range cfg.cf.AuthRadius.RS {
+		s := &cfg.cf.AuthRadius.RS[i]
+		if s.Name == name && s.GetAddress()+":"+strconv.Itoa(s.GetPort()) == addr {
+			return s, cfg.pool.AcquireChallengeResponse(i), true
+		}
 	}
+	return nil, nil, false
+}
 
-	s, err := cfg.RadiusServer(cfg.availableServers[0])
-	if err != nil {
-		return nil, err
+// AuthFailoverAttempts returns max number of servers tried for one authentication request.
+// By default all servers are tried
+func (cfg *AppConfig) AuthFailoverAttempts() int {
+	if cfg.file().Failover.MaxAttempts <= 0 {
+		return cfg.NumAuthServers()
 	}
-	return s, nil
+	return cfg.file().Failover.MaxAttempts
+}
+
+// AuthFailoverDeadline returns time limit for all attempts of one authentication request. Zero means no limit
+func (cfg *AppConfig) AuthFailoverDeadline() time.Duration {
+	return time.Duration(cfg.file().Failover.DeadlineSec) * time.Second
 }
 
 func (cfg *AppConfig) AvailableServersIDs() []int {
@@ -394,37 +1188,92 @@ func (cfg *AppConfig) AvailableServersIDs() []int {
 	return numSrv
 }
 
-func (cfg *AppConfig) GetAuthApiKey() string {
-	return cfg.cf.Srv.AuthApiKey
+// ClientIdentities returns callers identified by client certificates
+func (cfg *AppConfig) ClientIdentities() *certs.Identities {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	return cfg.identities
+}
+
+// RequestSigning returns verifier of request signatures. It is nil if signing is disabled
+func (cfg *AppConfig) RequestSigning() *signing.Verifier {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	return cfg.signing
+}
+
+// TLSOptions returns settings of tls of web server
+func (cfg *AppConfig) TLSOptions() certs.Options {
+	h := cfg.file().Srv.HTTPS
+	return certs.Options{
+		Certificate:    h.Certificate,
+		PrivateKey:     h.PrivateKey,
+		ClientCA:       h.ClientCA,
+		ClientAuth:     h.ClientAuth,
+		MinVersion:     h.MinVersion,
+		CipherSuites:   h.CipherSuites,
+		ReloadInterval: time.Duration(h.ReloadIntervalSec) * time.Second,
+	}
 }
 
-func (cfg *AppConfig) GetMonitoringApiKey() string {
-	return cfg.cf.Srv.Monitoring.ApiKey
+// ApiKeys returns api keys of callers
+func (cfg *AppConfig) ApiKeys() *apikey.Store {
+	cfg.m.RLock()
+	defer cfg.m.RUnlock()
+	return cfg.keys
 }
 
 func (cfg *AppConfig) GetMonitoringPath() string {
-	return cfg.cf.Srv.Monitoring.Path
+	return cfg.file().Srv.Monitoring.Path
+}
+
+func (cfg *AppConfig) IsAdminEnabled() bool {
+	return cfg.file().Srv.Admin.Enabled
+}
+
+func (cfg *AppConfig) IsMetricsEnabled() bool {
+	return cfg.file().Srv.Metrics.Enabled
+}
+
+func (cfg *AppConfig) GetMetricsPath() string {
+	return cfg.file().Srv.Metrics.Path
+}
+
+// MetricsListenAddress returns address of separate metrics listener or empty string
+// if metrics are served by the main web server
+func (cfg *AppConfig) MetricsListenAddress() string {
+	if cfg.file().Srv.Metrics.Port == 0 {
+		return ""
+	}
+	return cfg.file().Srv.Metrics.ListenAddress + ":" + strconv.Itoa(cfg.file().Srv.Metrics.Port)
 }
 func (cfg *AppConfig) NASID() string {
-	return cfg.cf.AuthRadius.NASID
+	return cfg.file().AuthRadius.NASID
 }
 func (cfg *AppConfig) NASIpV4Addr() net.IP {
-	return cfg.cf.AuthRadius.nasIpV4Addr
+	return cfg.file().AuthRadius.nasIpV4Addr
 }
 func (cfg *AppConfig) NASPort() uint32 {
-	return uint32(cfg.cf.AuthRadius.NASPort)
+	return uint32(cfg.file().AuthRadius.NASPort)
+}
+func (cfg *AppConfig) ChallengeTimeoutSec() int {
+	if cfg.file().AuthRadius.ChallengeTimeoutSec <= 0 {
+		return defaultChallengeTimeoutSec
+	}
+	return cfg.file().AuthRadius.ChallengeTimeoutSec
 }
 
 func (cfg *AppConfig) IsAuthCheckEnabled() bool {
-	return cfg.cf.AuthCheck.Enable
+	return cfg.file().AuthCheck.Enable
 }
 
 func (cfg *AppConfig) PrintConfig() {
-	cfg.l.Debugf("%#v", cfg)
-	cfg.l.Debugf("%#v", cfg.cf.AuthRadius)
-	cfg.l.Debugf("%#v", cfg.cf.AuthLDAP)
-	cfg.l.Debugf("%#v", cfg.cf.AuthCheck)
-	// cfg.l.Debug("%#v", cfg.cf.AuthCheck)
+	cf := cfg.file()
+	cfg.l.Debugf("%#v", cf)
+	cfg.l.Debugf("%#v", cf.AuthRadius)
+	cfg.l.Debugf("%#v", cf.AuthLDAP)
+	cfg.l.Debugf("%#v", cf.AuthCheck)
+	// cfg.l.Debug("%#v", cfg.file().AuthCheck)
 
 }
 
@@ -437,7 +1286,7 @@ func (sc *Server) GetPort() int {
 }
 
 func (sc *Server) GetAuthApiKey() string {
-	return sc.AuthApiKey
+	return sc.AuthApiKey.Value()
 }
 
 func (sc *Server) IsMonitoringEnabled() bool {
@@ -445,7 +1294,7 @@ func (sc *Server) IsMonitoringEnabled() bool {
 }
 
 func (sc *Server) GetMonitoringApiKey() string {
-	return sc.Monitoring.ApiKey
+	return sc.Monitoring.ApiKey.Value()
 }
 
 func (sc *Server) GetMonitoringPath() string {
@@ -472,7 +1321,7 @@ func (rc *RadiusSrv) GetPort() int {
 	return rc.Port
 }
 func (rc *RadiusSrv) GetSecret() string {
-	return rc.Secret
+	return rc.Secret.Value()
 }
 func (rc *RadiusSrv) GetProto() string {
 	return rc.Proto
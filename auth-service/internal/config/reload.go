@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Reload reads config file again and replaces settings of authentication servers, auth checks,
+// failover, circuit breaker, api keys, client certificate identities and request signing. Config is validated before anything is changed, so on error
+// the service keeps working with the current config. Latency, statistics, circuit breaker and
+// availability are kept for servers with the same name and address. Results of requests
+// which are in progress during reload are reported to the servers of the new config. New servers are available.
+// Changes of other sections are logged and applied after restart
+func (cfg *AppConfig) Reload(config_file string) error {
+	next, err := parseConfig(config_file)
+	if err != nil {
+		return err
+	}
+	cfg.m.Lock()
+	defer cfg.m.Unlock()
+	prev := &AppConfig{cf: cfg.cf}
+	if next.cf.AuthProviderType != prev.cf.AuthProviderType {
+		return fmt.Errorf("auth provider type can not be changed from %s to %s without restart", prev.cf.AuthProviderType, next.cf.AuthProviderType)
+	}
+	for _, section := range restartRequired(prev.cf, next.cf) {
+		cfg.l.Warnf("Changes of section %s are applied after restart", section)
+	}
+
+	cf := *prev.cf
+	cf.AuthRadius = next.cf.AuthRadius
+	cf.AuthLDAP = next.cf.AuthLDAP
+	cf.AuthCheck = next.cf.AuthCheck
+	cf.Failover = next.cf.Failover
+	cf.CircuitBreaker = next.cf.CircuitBreaker
+	cf.Srv = withCallers(cf.Srv, next.cf.Srv)
+
+	old := make(map[string]int, prev.NumAuthServers())
+	for i := 0; i < prev.NumAuthServers(); i++ {
+		old[serverKey(prev, i)] = i
+	}
+	num := next.NumAuthServers()
+	from := make([]int, num)
+	available := make([]int, 0, num)
+	unavailable := make([]int, 0, num)
+	for i := 0; i < num; i++ {
+		j, ok := old[serverKey(next, i)]
+		if !ok {
+			j = -1
+			cfg.l.Infof("Authentication server %s is added", next.AuthServerName(i))
+		}
+		from[i] = j
+		if j < 0 || containsInt(cfg.availableServers, j) {
+			available = append(available, i)
+		} else {
+			unavailable = append(unavailable, i)
+		}
+	}
+	next.pool.Inherit(cfg.pool, from)
+	next.pool.SetLogger(cfg.l)
+	next.pool.SetFailureListener(cfg.onFailure)
+	next.acctPool.Inherit(cfg.acctPool, accountingFrom(prev.cf, next.cf))
+	next.acctPool.SetLogger(cfg.l)
+
+	cfg.cf = &cf
+	cfg.pool = next.pool
+	cfg.acctPool = next.acctPool
+	cfg.keys = next.keys
+	cfg.identities = next.identities
+	cfg.signing = next.signing
+	cfg.availableServers = available
+	cfg.SetUnavailableServers(unavailable)
+	cfg.l.Infof("Config reloaded. %d authentication servers, %d available", num, len(available))
+	return nil
+}
+
+// serverKey identifies authentication server across reloads
+func serverKey(c *AppConfig, i int) string {
+	return c.AuthServerName(i) + "|" + c.AuthServerAddress(i)
+}
+
+// accountingFrom returns indexes of accounting servers of next config in prev config or -1 for new servers
+func accountingFrom(prev, next *ConfigFile) []int {
+	if prev.AuthRadius == nil || next.AuthRadius == nil {
+		return nil
+	}
+	key := func(s RadiusSrv) string {
+		return s.Name + "|" + s.Address + ":" + strconv.Itoa(s.Port)
+	}
+	old := make(map[string]int, len(prev.AuthRadius.Accounting.RS))
+	for i, s := range prev.AuthRadius.Accounting.RS {
+		old[key(s)] = i
+	}
+	from := make([]int, len(next.AuthRadius.Accounting.RS))
+	for i, s := range next.AuthRadius.Accounting.RS {
+		j, ok := old[key(s)]
+		if !ok {
+			j = -1
+		}
+		from[i] = j
+	}
+	return from
+}
+
+// restartRequired returns names of changed sections which are not applied by reload
+func restartRequired(prev, next *ConfigFile) []string {
+	var sections []string
+	for name, changed := range map[string]bool{
+		"log":           !reflect.DeepEqual(prev.L, next.L),
+		"web_server":    !reflect.DeepEqual(withCallers(prev.Srv, next.Srv), next.Srv),
+		"audit":         !reflect.DeepEqual(prev.Audit, next.Audit),
+		"throttle":      !reflect.DeepEqual(prev.Throttle, next.Throttle),
+		"coalesce":      !reflect.DeepEqual(prev.Coalesce, next.Coalesce),
+		"auth_cache":    !reflect.DeepEqual(prev.AuthCache, next.AuthCache),
+		"degraded_mode": !reflect.DeepEqual(prev.Degraded, next.Degraded),
+	} {
+		if changed {
+			sections = append(sections, name)
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
+
+// withCallers returns s with api keys, client certificate identities and request signing taken from from
+func withCallers(s, from Server) Server {
+	s.AuthApiKey = from.AuthApiKey
+	s.Monitoring.ApiKey = from.Monitoring.ApiKey
+	s.Metrics.ApiKey = from.Metrics.ApiKey
+	s.Admin.ApiKey = from.Admin.ApiKey
+	s.ApiKeys = from.ApiKeys
+	s.HTTPS.Clients = from.HTTPS.Clients
+	s.HTTPS.RequireApiKey = from.HTTPS.RequireApiKey
+	s.Signing = from.Signing
+	return s
+}
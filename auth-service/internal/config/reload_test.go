@@ -0,0 +1,276 @@
+package config
+
+import (
+	"auth-service/internal/globals"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `
+web_server:
+  port: %d
+  auth_api_key: %s
+log:
+  file: /tmp/auth-service.log
+  level: error
+auth_provider:
+  type: %s
+  circuit_breaker:
+    enable: true
+    failure_threshold: 3
+    cooldown_sec: 30
+  radius:
+    servers:%s
+  ldap:
+    bind_dn: cn=svc,dc=acme,dc=test
+    pass: pass
+    search_base: dc=acme,dc=test
+    search_filter: (uid=%%s)
+    verify_cert: true
+    servers:
+      - name: server1
+        address: 192.168.0.201
+        port: 389
+        response_timeout_sec: 5
+`
+
+// testRadiusServer returns config of radius server in servers list
+func testRadiusServer(name, address string) string {
+	return fmt.Sprintf(`
+      - name: %s
+        address: %s
+        port: 1812
+        protocol: pap
+        secret: secret
+        response_timeout_sec: 5`, name, address)
+}
+
+type testFile struct {
+	port     int
+	apiKey   string
+	provider string
+	servers  []string
+}
+
+// write writes config file to path
+func (f testFile) write(t *testing.T, path string) {
+	t.Helper()
+	if f.port == 0 {
+		f.port = 11245
+	}
+	if f.apiKey == "" {
+		f.apiKey = "key1"
+	}
+	if f.provider == "" {
+		f.provider = globals.AuthProviderRadius
+	}
+	data := fmt.Sprintf(testConfigFile, f.port, f.apiKey, f.provider, strings.Join(f.servers, ""))
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestConfig loads config with servers server1 and server2. Only server1 is available
+func loadTestConfig(t *testing.T) (*AppConfig, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	testFile{servers: []string{
+		testRadiusServer("server1", "192.168.0.201"),
+		testRadiusServer("server2", "192.168.0.202"),
+	}}.write(t, path)
+	cfg := NewConfig()
+	cfg.SetAppLogger(&globals.DummyLogger{})
+	if err := cfg.LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetAvailableServers([]int{0})
+	return cfg, path
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name string
+		file testFile
+		// name and state of servers after reload
+		servers []string
+		// consecutive failures of servers after reload
+		failures []int
+	}{
+		{
+			name: "same servers keep state",
+			file: testFile{servers: []string{
+				testRadiusServer("server1", "192.168.0.201"),
+				testRadiusServer("server2", "192.168.0.202"),
+			}},
+			servers:  []string{"server1 " + globals.ServerAvailable, "server2 " + globals.ServerUnavailable},
+			failures: []int{1, 0},
+		},
+		{
+			name: "servers are matched by name and address, not by index",
+			file: testFile{servers: []string{
+				testRadiusServer("server3", "192.168.0.203"),
+				testRadiusServer("server2", "192.168.0.202"),
+				testRadiusServer("server1", "192.168.0.201"),
+			}},
+			servers:  []string{"server3 " + globals.ServerAvailable, "server2 " + globals.ServerUnavailable, "server1 " + globals.ServerAvailable},
+			failures: []int{0, 0, 1},
+		},
+		{
+			name: "moved server is new",
+			file: testFile{servers: []string{
+				testRadiusServer("server1", "192.168.0.211"),
+				testRadiusServer("server2", "192.168.0.212"),
+			}},
+			servers:  []string{"server1 " + globals.ServerAvailable, "server2 " + globals.ServerAvailable},
+			failures: []int{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, path := loadTestConfig(t)
+			i, _, done, err := cfg.GetAvailableRadiusAuthServer("user", nil)
+			if err != nil || i != 0 {
+				t.Fatalf("GetAvailableRadiusAuthServer() = %d, %v", i, err)
+			}
+			done(globals.ErrServerTimeout)
+
+			tt.file.write(t, path)
+			if err := cfg.Reload(path); err != nil {
+				t.Fatal(err)
+			}
+			var servers []string
+			var failures []int
+			for _, ss := range cfg.AuthServersStatus().Servers {
+				servers = append(servers, ss.Name+" "+ss.State)
+				failures = append(failures, ss.ConsecutiveFailures)
+			}
+			if !reflect.DeepEqual(servers, tt.servers) {
+				t.Errorf("servers = %v, want %v", servers, tt.servers)
+			}
+			if !reflect.DeepEqual(failures, tt.failures) {
+				t.Errorf("consecutive failures = %v, want %v", failures, tt.failures)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		file testFile
+		err  string
+	}{
+		{"provider type", testFile{provider: globals.AuthProviderLDAP}, "auth provider type can not be changed"},
+		{"invalid config", testFile{servers: []string{testRadiusServer("server1", "")}}, "auth_provider.radius.servers[0].address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, path := loadTestConfig(t)
+			tt.file.write(t, path)
+			err := cfg.Reload(path)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Reload() error = %v, want %s", err, tt.err)
+			}
+			if cfg.AuthProviderType() != globals.AuthProviderRadius || cfg.NumAuthServers() != 2 || !cfg.IsAuthServerAvailable(0) {
+				t.Error("config is changed by failed reload")
+			}
+		})
+	}
+}
+
+func TestReloadCallers(t *testing.T) {
+	cfg, path := loadTestConfig(t)
+	testFile{port: 8080, apiKey: "key2", servers: []string{testRadiusServer("server1", "192.168.0.201")}}.write(t, path)
+	if err := cfg.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if key := cfg.file().Srv.AuthApiKey.Value(); key != "key2" {
+		t.Errorf("auth_api_key = %s after reload, want key2", key)
+	}
+	if port := cfg.file().Srv.Port; port != 11245 {
+		t.Errorf("port = %d after reload, want 11245 until restart", port)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(cf *ConfigFile)
+		sections []string
+	}{
+		{"nothing", func(cf *ConfigFile) {}, nil},
+		{"servers", func(cf *ConfigFile) {
+			cf.AuthRadius.RS = cf.AuthRadius.RS[:1]
+			cf.Failover.MaxAttempts = 1
+			cf.CircuitBreaker.Enable = true
+		}, nil},
+		{"api keys and signing", func(cf *ConfigFile) {
+			cf.Srv.AuthApiKey = "other"
+			cf.Srv.Admin.ApiKey = "admin"
+			cf.Srv.ApiKeys = []ApiKey{{Name: "vpn"}}
+			cf.Srv.Signing.Enable = true
+			cf.Srv.HTTPS.Clients = []ClientCert{{Name: "vpn"}}
+		}, nil},
+		{"web server", func(cf *ConfigFile) {
+			cf.Srv.Port = 8080
+			cf.Srv.Admin.Enabled = true
+		}, []string{"web_server"}},
+		{"other sections", func(cf *ConfigFile) {
+			cf.L.Level = "debug"
+			cf.Audit.Enable = true
+			cf.Throttle.Enable = true
+			cf.Coalesce.Enable = true
+			cf.AuthCache.TTLSec = 60
+			cf.Degraded.ExcludeUsers = []string{"ceo"}
+		}, []string{"audit", "auth_cache", "coalesce", "degraded_mode", "log", "throttle"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := validConfig()
+			tt.change(next)
+			if sections := restartRequired(validConfig(), next); !reflect.DeepEqual(sections, tt.sections) {
+				t.Errorf("restartRequired() = %v, want %v", sections, tt.sections)
+			}
+		})
+	}
+}
+
+func TestAccountingFrom(t *testing.T) {
+	srv := func(name, address string, port int) RadiusSrv {
+		return RadiusSrv{Name: name, Address: address, Port: port}
+	}
+	withAccounting := func(rs ...RadiusSrv) *ConfigFile {
+		cf := validConfig()
+		cf.AuthRadius.Accounting = RadiusAccounting{Enable: true, RS: rs}
+		return cf
+	}
+	prev := withAccounting(srv("acct1", "192.168.0.201", 1813), srv("acct2", "192.168.0.202", 1813))
+	tests := []struct {
+		name string
+		next *ConfigFile
+		from []int
+	}{
+		{"same", withAccounting(srv("acct1", "192.168.0.201", 1813), srv("acct2", "192.168.0.202", 1813)), []int{0, 1}},
+		{"reordered", withAccounting(srv("acct2", "192.168.0.202", 1813), srv("acct1", "192.168.0.201", 1813)), []int{1, 0}},
+		{"added", withAccounting(srv("acct3", "192.168.0.203", 1813), srv("acct1", "192.168.0.201", 1813)), []int{-1, 0}},
+		{"other port", withAccounting(srv("acct1", "192.168.0.201", 1646)), []int{-1}},
+		{"renamed", withAccounting(srv("acct", "192.168.0.201", 1813)), []int{-1}},
+		{"disabled", withAccounting(), []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if from := accountingFrom(prev, tt.next); !reflect.DeepEqual(from, tt.from) {
+				t.Errorf("accountingFrom() = %v, want %v", from, tt.from)
+			}
+		})
+	}
+	ldap := validConfig()
+	validLDAP(ldap)
+	if from := accountingFrom(prev, ldap); from != nil {
+		t.Errorf("accountingFrom() of ldap config = %v, want nil", from)
+	}
+}
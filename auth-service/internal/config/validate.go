@@ -0,0 +1,584 @@
+package config
+
+import (
+	"auth-service/internal/apikey"
+	"auth-service/internal/audit"
+	"auth-service/internal/certs"
+	"auth-service/internal/globals"
+	"auth-service/internal/openapi"
+	"auth-service/internal/pool"
+	"auth-service/internal/pwhash"
+	"auth-service/internal/syslog"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Problem is a mistake found by validation of config file. Service doesn't start
+// with errors, warnings are logged
+type Problem struct {
+	// option in config file, for example auth_provider.radius.servers[0].port
+	Path    string
+	Msg     string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Msg
+}
+
+// ValidationError is returned when config file has errors. Problems include warnings
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, p := range e.Problems {
+		if !p.Warning {
+			msgs = append(msgs, p.String())
+		}
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// CheckConfig reads and validates config file. All found problems are returned.
+// Error is returned if config file can not be read or parsed
+func CheckConfig(config_file string) ([]Problem, error) {
+	cfg, err := parseConfig(config_file)
+	if ve, ok := err.(*ValidationError); ok {
+		return ve.Problems, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg.file().validate(), nil
+}
+
+// Warnings returns problems of current config which don't prevent start of service
+func (cfg *AppConfig) Warnings() []Problem {
+	return cfg.file().validate()
+}
+
+func hasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Path: path, Msg: fmt.Sprintf(format, args...), Warning: true})
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.errorf(path, "port %d is out of range 1-65535", port)
+	}
+}
+
+func (v *validator) notNegative(path string, val int) {
+	if val < 0 {
+		v.errorf(path, "must not be negative")
+	}
+}
+
+func (v *validator) file(path, name string) {
+	if name == "" {
+		v.errorf(path, "file is not set")
+		return
+	}
+	if _, err := os.Stat(name); err != nil {
+		v.errorf(path, "%s", err)
+	}
+}
+
+func (v *validator) syslog(path string, s *Syslog) {
+	if !s.Enable {
+		return
+	}
+	// connection is checked only by check-config -connect, so slow server doesn't delay reload
+	if err := syslog.Check(s.options()); err != nil {
+		v.errorf(path, "%s", err)
+	}
+}
+
+// validate checks values of all sections of config
+func (cf *ConfigFile) validate() []Problem {
+	v := &validator{}
+	v.log(&cf.L)
+	v.webServer(&cf.Srv)
+	v.audit(&cf.Audit)
+	v.throttle(&cf.Throttle)
+	v.coalesce(&cf.Coalesce)
+	if cf.AuthCache.Enable && cf.AuthCache.TTLSec <= 0 {
+		v.errorf("auth_cache.ttl_sec", "must be positive")
+	}
+	v.degraded(&cf.Degraded)
+
+	var servers []string
+	switch cf.AuthProviderType {
+	case globals.AuthProviderRadius:
+		servers = v.radius(cf.AuthRadius)
+	case globals.AuthProviderLDAP:
+		servers = v.ldap(cf.AuthLDAP)
+	default:
+		v.errorf("auth_provider.type", "unsupported auth provider type %q", cf.AuthProviderType)
+	}
+	v.authCheck(cf.AuthCheck, cf.AuthProviderType, servers)
+	v.notNegative("auth_provider.failover.max_attempts", cf.Failover.MaxAttempts)
+	v.notNegative("auth_provider.failover.deadline_sec", cf.Failover.DeadlineSec)
+	if cf.CircuitBreaker.Enable {
+		if cf.CircuitBreaker.FailureThreshold <= 0 {
+			v.errorf("auth_provider.circuit_breaker.failure_threshold", "must be positive")
+		}
+		if cf.CircuitBreaker.CooldownSec <= 0 {
+			v.errorf("auth_provider.circuit_breaker.cooldown_sec", "must be positive")
+		}
+	}
+	return v.problems
+}
+
+func (v *validator) log(l *Log) {
+	if l.File == "" {
+		v.errorf("log.file", "file is not set")
+	}
+	switch l.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		v.warnf("log.level", "unknown level %q, error is used", l.Level)
+	}
+	v.syslog("log.syslog", &l.Syslog)
+}
+
+func (v *validator) webServer(s *Server) {
+	v.port("web_server.port", s.Port)
+	scopes := v.apiKeys(s.ApiKeys)
+	if s.HTTPS.Enable {
+		v.https(&s.HTTPS, scopes)
+	} else if len(s.HTTPS.Clients) > 0 || s.HTTPS.RequireApiKey {
+		v.warnf("web_server.https.clients", "client certificates are used only with https")
+	}
+	v.signing(&s.Signing)
+	if s.AuthApiKey == "" && !scopes[apikey.ScopeAuth] {
+		v.warnf("web_server.auth_api_key", "is empty, requests without X-Api-Key header are accepted")
+	}
+	if s.Monitoring.Enabled {
+		v.path("web_server.status.path", s.Monitoring.Path)
+		if s.Monitoring.ApiKey == "" && !scopes[apikey.ScopeStatus] {
+			v.warnf("web_server.status.api_key", "is empty")
+		}
+	}
+	if s.Metrics.Enabled {
+		v.path("web_server.metrics.path", s.Metrics.Path)
+		if s.Metrics.Port == 0 {
+			if s.Metrics.ApiKey == "" && !scopes[apikey.ScopeMetrics] {
+				v.errorf("web_server.metrics.api_key", "must be set when metrics are served on the main port")
+			}
+		} else {
+			v.port("web_server.metrics.port", s.Metrics.Port)
+			if s.Metrics.Port == s.Port {
+				v.errorf("web_server.metrics.port", "must differ from web_server.port")
+			}
+		}
+	}
+	if s.Admin.Enabled && s.Admin.ApiKey == "" && !scopes[apikey.ScopeAdmin] {
+		v.errorf("web_server.admin.api_key", "must be set when admin api is enabled")
+	}
+}
+
+// https adds scopes of client certificate identities to scopes
+func (v *validator) https(h *HTTPSConfig, scopes map[string]bool) {
+	const path = "web_server.https"
+	v.file(path+".certificate", h.Certificate)
+	v.file(path+".private_key", h.PrivateKey)
+	if h.ClientCA != "" {
+		v.file(path+".client_ca", h.ClientCA)
+	}
+	if _, err := certs.ParseVersion(h.MinVersion); err != nil {
+		v.errorf(path+".min_version", "%s", err)
+	}
+	if _, err := certs.ParseCipherSuites(h.CipherSuites); err != nil {
+		v.errorf(path+".cipher_suites", "%s", err)
+	} else if len(h.CipherSuites) > 0 && h.MinVersion == "1.3" {
+		v.warnf(path+".cipher_suites", "cipher suites of tls 1.3 are not configurable")
+	}
+	ca, err := certs.ParseClientAuth(h.ClientAuth, h.ClientCA)
+	if err != nil {
+		v.errorf(path+".client_auth", "%s", err)
+	}
+	v.notNegative(path+".reload_interval_sec", h.ReloadIntervalSec)
+	if (len(h.Clients) > 0 || h.RequireApiKey) && ca == tls.NoClientCert {
+		v.errorf(path+".client_ca", "client certificates must be verified to identify callers")
+	}
+	names := make([]string, 0, len(h.Clients))
+	for i, c := range h.Clients {
+		p := fmt.Sprintf("%s.clients[%d]", path, i)
+		names = v.uniqueName(p+".name", c.Name, names)
+		if c.CommonName == "" && c.DNSName == "" && c.URI == "" && c.Email == "" {
+			v.errorf(p, "one of common_name, dns_name, uri or email must be set")
+		}
+		if len(c.Scopes) == 0 {
+			v.errorf(p+".scopes", "no scopes")
+		}
+		for _, sc := range c.Scopes {
+			if !apikey.ValidScope(sc) {
+				v.errorf(p+".scopes", "unknown scope %q", sc)
+			}
+			if !h.RequireApiKey {
+				scopes[sc] = true
+			}
+		}
+	}
+}
+
+// path checks url path of route. Paths of versioned api and OpenAPI document are reserved
+func (v *validator) path(path, p string) {
+	switch {
+	case !strings.HasPrefix(p, "/"):
+		v.errorf(path, "must start with /")
+	case p == "/v1" || strings.HasPrefix(p, "/v1/"):
+		v.errorf(path, "/v1 is reserved for versioned api")
+	case p == openapi.Path:
+		v.errorf(path, "%s is reserved for OpenAPI document", openapi.Path)
+	}
+}
+
+// shorter secrets of request signing are reported
+const minSigningSecret = 32
+
+func (v *validator) signing(s *Signing) {
+	const path = "web_server.request_signing"
+	if !s.Enable {
+		return
+	}
+	v.notNegative(path+".max_skew_sec", s.MaxSkewSec)
+	if len(s.Keys) == 0 {
+		v.errorf(path+".keys", "no keys")
+	}
+	names := make([]string, 0, len(s.Keys))
+	for i, k := range s.Keys {
+		p := fmt.Sprintf("%s.keys[%d]", path, i)
+		names = v.uniqueName(p+".name", k.Name, names)
+		switch {
+		case k.Secret == "":
+			v.errorf(p+".secret", "is empty")
+		case len(k.Secret) < minSigningSecret:
+			v.warnf(p+".secret", "is shorter than %d bytes", minSigningSecret)
+		}
+	}
+	if s.AllowUnsigned {
+		v.warnf(path+".allow_unsigned", "unsigned requests are accepted and can be replayed")
+	}
+}
+
+// apiKeys returns scopes which have at least one named key
+func (v *validator) apiKeys(keys []ApiKey) map[string]bool {
+	scopes := make(map[string]bool)
+	names := make([]string, 0, len(keys))
+	now := time.Now()
+	for i, k := range keys {
+		p := fmt.Sprintf("web_server.api_keys[%d]", i)
+		names = v.uniqueName(p+".name", k.Name, names)
+		if err := pwhash.Check(k.Hash.Value()); err != nil {
+			v.errorf(p+".hash", "%s", err)
+		}
+		if len(k.Scopes) == 0 {
+			v.errorf(p+".scopes", "no scopes")
+		}
+		for _, sc := range k.Scopes {
+			if !apikey.ValidScope(sc) {
+				v.errorf(p+".scopes", "unknown scope %q", sc)
+			}
+			scopes[sc] = true
+		}
+		nb, exp, err := k.times()
+		switch {
+		case err != nil:
+			v.errorf(p, "invalid time. %s", err)
+		case !exp.IsZero() && !nb.IsZero() && !exp.After(nb):
+			v.errorf(p+".expires", "must be after not_before")
+		case !exp.IsZero() && !now.Before(exp):
+			v.warnf(p+".expires", "key %s is expired", k.Name)
+		}
+	}
+	return scopes
+}
+
+func (v *validator) audit(a *Audit) {
+	if !a.Enable {
+		return
+	}
+	if a.File == "" && !a.Syslog.Enable {
+		v.errorf("audit", "file or syslog must be set when audit is enabled")
+	}
+	v.notNegative("audit.max_size_mb", a.MaxSizeMb)
+	v.notNegative("audit.max_backups", a.MaxBackups)
+	v.notNegative("audit.max_age_days", a.MaxAgeDays)
+	if a.Syslog.Enable {
+		if _, err := audit.Format(a.Syslog.Format); err != nil {
+			v.errorf("audit.syslog.format", "%s", err)
+		}
+	}
+	v.syslog("audit.syslog", &a.Syslog)
+}
+
+func (v *validator) throttle(t *Throttle) {
+	if !t.Enable {
+		return
+	}
+	rules := []struct {
+		path string
+		r    ThrottleRule
+	}{
+		{"throttle.user", t.User},
+		{"throttle.client_ip", t.ClientIP},
+		{"throttle.user_ip", t.UserIP},
+	}
+	active := 0
+	for _, tr := range rules {
+		v.notNegative(tr.path+".max_failures", tr.r.MaxFailures)
+		if tr.r.MaxFailures <= 0 {
+			continue
+		}
+		active++
+		if tr.r.WindowSec <= 0 {
+			v.errorf(tr.path+".window_sec", "must be positive")
+		}
+		if tr.r.LockoutSec <= 0 {
+			v.errorf(tr.path+".lockout_sec", "must be positive")
+		}
+		if tr.r.MaxLockoutSec != 0 && tr.r.MaxLockoutSec < tr.r.LockoutSec {
+			v.errorf(tr.path+".max_lockout_sec", "must not be less than lockout_sec")
+		}
+	}
+	if active == 0 {
+		v.warnf("throttle", "is enabled, but max_failures of all rules is 0")
+	}
+}
+
+func (v *validator) coalesce(c *Coalesce) {
+	v.notNegative("coalesce.mfa_limit.max_requests", c.MFALimit.MaxRequests)
+	if c.MFALimit.MaxRequests > 0 && c.MFALimit.WindowSec <= 0 {
+		v.errorf("coalesce.mfa_limit.window_sec", "must be positive")
+	}
+}
+
+func (v *validator) degraded(d *Degraded) {
+	if !d.Enable {
+		return
+	}
+	if d.StoreFile == "" {
+		v.errorf("degraded_mode.store_file", "must be set when degraded mode is enabled")
+	}
+	v.notNegative("degraded_mode.max_age_hours", d.MaxAgeHours)
+	users := make(map[string]bool)
+	for i, bg := range d.BreakGlass {
+		path := fmt.Sprintf("degraded_mode.break_glass[%d]", i)
+		// users are compared case insensitively as in the rest of login path
+		user := strings.ToLower(bg.User)
+		if bg.User == "" {
+			v.errorf(path+".user", "is empty")
+		} else if users[user] {
+			v.errorf(path+".user", "duplicate user %s", bg.User)
+		}
+		users[user] = true
+		if err := pwhash.Check(bg.PasswordHash.Value()); err != nil {
+			v.errorf(path+".password_hash", "%s", err)
+		}
+	}
+}
+
+// radius returns names of authentication servers
+func (v *validator) radius(r *AuthRadius) []string {
+	const path = "auth_provider.radius"
+	if r == nil {
+		v.errorf(path, "section is missing")
+		return nil
+	}
+	if _, err := pool.NewStrategy(r.Selection); err != nil {
+		v.errorf(path+".selection", "%s", err)
+	}
+	if r.NASIpV4AddrStr != "" {
+		if ip := net.ParseIP(r.NASIpV4AddrStr); ip == nil || ip.To4() == nil {
+			v.errorf(path+".nas_ipv4_address", "%q is not ipv4 address", r.NASIpV4AddrStr)
+		}
+	}
+	v.notNegative(path+".nas_port", r.NASPort)
+	v.notNegative(path+".challenge_timeout_sec", r.ChallengeTimeoutSec)
+	if len(r.RS) == 0 {
+		v.errorf(path+".servers", "no servers")
+	}
+	names := v.radiusServers(path+".servers", r.RS, true)
+	if r.Accounting.Enable {
+		if len(r.Accounting.RS) == 0 {
+			v.errorf(path+".accounting.servers", "no servers")
+		}
+		if _, err := pool.NewStrategy(r.Accounting.Selection); err != nil {
+			v.errorf(path+".accounting.selection", "%s", err)
+		}
+		v.radiusServers(path+".accounting.servers", r.Accounting.RS, false)
+	}
+	return names
+}
+
+func (v *validator) radiusServers(path string, servers []RadiusSrv, auth bool) []string {
+	names := make([]string, 0, len(servers))
+	for i, s := range servers {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		names = v.uniqueName(p+".name", s.Name, names)
+		if s.Address == "" {
+			v.errorf(p+".address", "is empty")
+		}
+		v.port(p+".port", s.Port)
+		if s.Secret == "" {
+			v.errorf(p+".secret", "is empty")
+		}
+		if auth && s.Proto != "pap" && s.Proto != "mschapv2" {
+			v.errorf(p+".protocol", "unsupported protocol %q, use pap or mschapv2", s.Proto)
+		}
+		if s.ResponseTimeoutSec <= 0 {
+			v.errorf(p+".response_timeout_sec", "must be positive")
+		}
+		v.notNegative(p+".weight", s.Weight)
+	}
+	return names
+}
+
+// ldap returns names of authentication servers
+func (v *validator) ldap(l *AuthLDAP) []string {
+	const path = "auth_provider.ldap"
+	if l == nil {
+		v.errorf(path, "section is missing")
+		return nil
+	}
+	if _, err := pool.NewStrategy(l.Selection); err != nil {
+		v.errorf(path+".selection", "%s", err)
+	}
+	if l.BindDN == "" {
+		v.errorf(path+".bind_dn", "is empty")
+	}
+	if l.Password == "" {
+		v.errorf(path+".pass", "is empty")
+	}
+	if l.SearchBase == "" {
+		v.errorf(path+".search_base", "is empty")
+	}
+	if strings.Count(l.SearchFilter, "%s") != 1 || strings.Count(l.SearchFilter, "%") != 1 {
+		v.errorf(path+".search_filter", "must contain exactly one %%s placeholder for login")
+	}
+	if !l.VerifyCert {
+		v.warnf(path+".verify_cert", "certificates of ldaps servers are not verified")
+	}
+	if len(l.LS) == 0 {
+		v.errorf(path+".servers", "no servers")
+	}
+	names := make([]string, 0, len(l.LS))
+	for i, s := range l.LS {
+		p := fmt.Sprintf("%s.servers[%d]", path, i)
+		names = v.uniqueName(p+".name", s.Name, names)
+		if s.Address == "" {
+			v.errorf(p+".address", "is empty")
+		}
+		v.port(p+".port", s.Port)
+		v.notNegative(p+".response_timeout_sec", s.ResponseTimeoutSec)
+		if s.ResponseTimeoutSec == 0 {
+			v.warnf(p+".response_timeout_sec", "is 0, requests are not limited in time")
+		}
+		v.notNegative(p+".weight", s.Weight)
+	}
+	return names
+}
+
+// uniqueName checks that name is set and unique. Name is appended to names
+func (v *validator) uniqueName(path, name string, names []string) []string {
+	if name == "" {
+		v.errorf(path, "is empty")
+		return names
+	}
+	if containsString(names, name) {
+		v.errorf(path, "duplicate name %s", name)
+		return names
+	}
+	return append(names, name)
+}
+
+func containsString(arr []string, v string) bool {
+	for _, x := range arr {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) authCheck(ac *AuthCheck, provider string, servers []string) {
+	const path = "auth_provider.auth_check"
+	if ac == nil || !ac.Enable {
+		return
+	}
+	if ac.IntervalSec <= 0 {
+		v.errorf(path+".interval_sec", "must be positive")
+	}
+	v.notNegative(path+".jitter_sec", ac.JitterSec)
+	v.notNegative(path+".timeout_sec", ac.TimeoutSec)
+	v.notNegative(path+".rise", ac.Rise)
+	v.notNegative(path+".fall", ac.Fall)
+	if m := checkMethod(ac.Method, ""); !validCheckMethod(m, provider) {
+		v.errorf(path+".method", "method %s is not supported by auth provider %s", m, provider)
+	}
+	overrides := make(map[string]string, len(ac.Servers))
+	for i, s := range ac.Servers {
+		p := fmt.Sprintf("%s.servers[%d]", path, i)
+		if !containsString(servers, s.Name) {
+			v.errorf(p+".name", "unknown server %q", s.Name)
+		}
+		v.notNegative(p+".interval_sec", s.IntervalSec)
+		if m := checkMethod(ac.Method, s.Method); !validCheckMethod(m, provider) {
+			v.errorf(p+".method", "method %s is not supported by auth provider %s", m, provider)
+		}
+		overrides[s.Name] = s.Method
+	}
+	credentials := false
+	for _, n := range servers {
+		credentials = credentials || checkMethod(ac.Method, overrides[n]) == globals.AuthCheckCredentials
+	}
+	if credentials && (ac.User == "" || ac.Pass == "") {
+		v.errorf(path+".user", "user and pass must be set for method %s", globals.AuthCheckCredentials)
+	}
+}
+
+// checkMethod returns method of server check. Default method is credentials
+func checkMethod(method, serverMethod string) string {
+	if serverMethod != "" {
+		return serverMethod
+	}
+	if method == "" {
+		return globals.AuthCheckCredentials
+	}
+	return method
+}
+
+func validCheckMethod(m, provider string) bool {
+	switch {
+	case m == globals.AuthCheckCredentials:
+	case m == globals.AuthCheckStatusServer && provider == globals.AuthProviderRadius:
+	case m == globals.AuthCheckLDAPBind && provider == globals.AuthProviderLDAP:
+	default:
+		return false
+	}
+	return true
+}
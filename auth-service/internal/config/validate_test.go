@@ -0,0 +1,279 @@
+package config
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/pwhash"
+	"auth-service/internal/secret"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// validConfig returns config with radius provider which has no problems
+func validConfig() *ConfigFile {
+	return &ConfigFile{
+		L:                Log{File: "/tmp/auth-service.log", Level: "error"},
+		Srv:              Server{Port: 11245, AuthApiKey: "123456789"},
+		AuthProviderType: globals.AuthProviderRadius,
+		AuthRadius: &AuthRadius{RS: []RadiusSrv{
+			{Name: "server1", Address: "192.168.0.201", Port: 1812, Secret: "secret", Proto: "pap", ResponseTimeoutSec: 15},
+			{Name: "server2", Address: "192.168.0.202", Port: 1812, Secret: "secret", Proto: "mschapv2", ResponseTimeoutSec: 15},
+		}},
+		AuthCheck: &AuthCheck{},
+	}
+}
+
+func validLDAP(cf *ConfigFile) {
+	cf.AuthProviderType = globals.AuthProviderLDAP
+	cf.AuthRadius = nil
+	cf.AuthLDAP = &AuthLDAP{
+		BindDN:       "cn=svc,dc=acme,dc=test",
+		Password:     "pass",
+		SearchBase:   "dc=acme,dc=test",
+		SearchFilter: "(sAMAccountName=%s)",
+		VerifyCert:   true,
+		LS:           []LDAPServer{{Name: "server1", Address: "192.168.0.201", Port: 389, ResponseTimeoutSec: 15}},
+	}
+}
+
+// paths returns sorted paths of errors and warnings
+func paths(problems []Problem) (errs, warnings []string) {
+	for _, p := range problems {
+		if p.Warning {
+			warnings = append(warnings, p.Path)
+		} else {
+			errs = append(errs, p.Path)
+		}
+	}
+	sort.Strings(errs)
+	sort.Strings(warnings)
+	return errs, warnings
+}
+
+func TestValidate(t *testing.T) {
+	hash, err := pwhash.Hash("pass", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		change   func(cf *ConfigFile)
+		errs     []string
+		warnings []string
+	}{
+		{"valid", func(cf *ConfigFile) {}, nil, nil},
+		{"log", func(cf *ConfigFile) {
+			cf.L = Log{Level: "trace"}
+		}, []string{"log.file"}, []string{"log.level"}},
+		{"web server port", func(cf *ConfigFile) {
+			cf.Srv.Port = 70000
+		}, []string{"web_server.port"}, nil},
+		{"requests without api key", func(cf *ConfigFile) {
+			cf.Srv.AuthApiKey = ""
+		}, nil, []string{"web_server.auth_api_key"}},
+		{"reserved status path", func(cf *ConfigFile) {
+			cf.Srv.Monitoring = Monitoring{Enabled: true, Path: "/v1/status", ApiKey: "key"}
+		}, []string{"web_server.status.path"}, nil},
+		{"metrics on main port without api key", func(cf *ConfigFile) {
+			cf.Srv.Metrics = Metrics{Enabled: true, Path: "/metrics"}
+		}, []string{"web_server.metrics.api_key"}, nil},
+		{"metrics port of web server", func(cf *ConfigFile) {
+			cf.Srv.Metrics = Metrics{Enabled: true, Path: "/metrics", Port: 11245}
+		}, []string{"web_server.metrics.port"}, nil},
+		{"admin without api key", func(cf *ConfigFile) {
+			cf.Srv.Admin.Enabled = true
+		}, []string{"web_server.admin.api_key"}, nil},
+		{"named api keys", func(cf *ConfigFile) {
+			cf.Srv.ApiKeys = []ApiKey{
+				{Name: "vpn", Hash: "plain", Scopes: []string{"auth"}},
+				{Name: "vpn", Hash: "pbkdf2-sha256$1000$c2FsdA$a2V5", Scopes: []string{"root"}},
+				{Name: "mon", Hash: "pbkdf2-sha256$1000$c2FsdA$a2V5"},
+				{Name: "old", Hash: "pbkdf2-sha256$1000$c2FsdA$a2V5", Scopes: []string{"auth"}, Expires: "2020-01-01T00:00:00Z"},
+				{Name: "new", Hash: "pbkdf2-sha256$1000$c2FsdA$a2V5", Scopes: []string{"auth"}, NotBefore: "2030-01-02T00:00:00Z", Expires: "2030-01-01T00:00:00Z"},
+			}
+		}, []string{
+			"web_server.api_keys[0].hash",
+			"web_server.api_keys[1].name",
+			"web_server.api_keys[1].scopes",
+			"web_server.api_keys[2].scopes",
+			"web_server.api_keys[4].expires",
+		}, []string{"web_server.api_keys[3].expires"}},
+		{"request signing", func(cf *ConfigFile) {
+			cf.Srv.Signing = Signing{Enable: true, AllowUnsigned: true, Keys: []SigningKey{
+				{Name: "vpn", Secret: "short"},
+				{Name: "vpn2"},
+			}}
+		}, []string{"web_server.request_signing.keys[1].secret"}, []string{
+			"web_server.request_signing.allow_unsigned",
+			"web_server.request_signing.keys[0].secret",
+		}},
+		{"request signing without keys", func(cf *ConfigFile) {
+			cf.Srv.Signing = Signing{Enable: true}
+		}, []string{"web_server.request_signing.keys"}, nil},
+		{"audit without file and syslog", func(cf *ConfigFile) {
+			cf.Audit = Audit{Enable: true, MaxBackups: -1}
+		}, []string{"audit", "audit.max_backups"}, nil},
+		{"throttle rules", func(cf *ConfigFile) {
+			cf.Throttle = Throttle{
+				Enable: true,
+				User:   ThrottleRule{MaxFailures: 5, LockoutSec: 60, MaxLockoutSec: 30},
+				UserIP: ThrottleRule{MaxFailures: -1},
+			}
+		}, []string{
+			"throttle.user.max_lockout_sec",
+			"throttle.user.window_sec",
+			"throttle.user_ip.max_failures",
+		}, nil},
+		{"throttle without rules", func(cf *ConfigFile) {
+			cf.Throttle.Enable = true
+		}, nil, []string{"throttle"}},
+		{"mfa limit without window", func(cf *ConfigFile) {
+			cf.Coalesce.MFALimit.MaxRequests = 3
+		}, []string{"coalesce.mfa_limit.window_sec"}, nil},
+		{"auth cache without ttl", func(cf *ConfigFile) {
+			cf.AuthCache.Enable = true
+		}, []string{"auth_cache.ttl_sec"}, nil},
+		{"degraded mode", func(cf *ConfigFile) {
+			cf.Degraded = Degraded{Enable: true, MaxAgeHours: -1, BreakGlass: []BreakGlass{
+				{User: "admin", PasswordHash: secret.Secret(hash)},
+				{User: "ADMIN", PasswordHash: secret.Secret(hash)},
+				{User: "", PasswordHash: "glass"},
+			}}
+		}, []string{
+			"degraded_mode.break_glass[1].user",
+			"degraded_mode.break_glass[2].password_hash",
+			"degraded_mode.break_glass[2].user",
+			"degraded_mode.max_age_hours",
+			"degraded_mode.store_file",
+		}, nil},
+		{"unsupported provider", func(cf *ConfigFile) {
+			cf.AuthProviderType = "kerberos"
+		}, []string{"auth_provider.type"}, nil},
+		{"missing radius section", func(cf *ConfigFile) {
+			cf.AuthRadius = nil
+		}, []string{"auth_provider.radius"}, nil},
+		{"radius settings", func(cf *ConfigFile) {
+			cf.AuthRadius.Selection = "random"
+			cf.AuthRadius.NASIpV4AddrStr = "::1"
+			cf.AuthRadius.ChallengeTimeoutSec = -1
+		}, []string{
+			"auth_provider.radius.challenge_timeout_sec",
+			"auth_provider.radius.nas_ipv4_address",
+			"auth_provider.radius.selection",
+		}, nil},
+		{"radius servers", func(cf *ConfigFile) {
+			cf.AuthRadius.RS = append(cf.AuthRadius.RS, RadiusSrv{Name: "server1", Port: 0, Proto: "chap", Weight: -1})
+		}, []string{
+			"auth_provider.radius.servers[2].address",
+			"auth_provider.radius.servers[2].name",
+			"auth_provider.radius.servers[2].port",
+			"auth_provider.radius.servers[2].protocol",
+			"auth_provider.radius.servers[2].response_timeout_sec",
+			"auth_provider.radius.servers[2].secret",
+			"auth_provider.radius.servers[2].weight",
+		}, nil},
+		{"no radius servers", func(cf *ConfigFile) {
+			cf.AuthRadius.RS = nil
+		}, []string{"auth_provider.radius.servers"}, nil},
+		{"accounting", func(cf *ConfigFile) {
+			cf.AuthRadius.Accounting = RadiusAccounting{Enable: true, Selection: "random"}
+		}, []string{
+			"auth_provider.radius.accounting.selection",
+			"auth_provider.radius.accounting.servers",
+		}, nil},
+		{"accounting servers don't need protocol", func(cf *ConfigFile) {
+			cf.AuthRadius.Accounting = RadiusAccounting{Enable: true, RS: []RadiusSrv{
+				{Name: "server1", Address: "192.168.0.201", Port: 1813, Secret: "secret", ResponseTimeoutSec: 5},
+			}}
+		}, nil, nil},
+		{"ldap", func(cf *ConfigFile) {
+			validLDAP(cf)
+		}, nil, nil},
+		{"ldap settings", func(cf *ConfigFile) {
+			validLDAP(cf)
+			cf.AuthLDAP.SearchFilter = "(&(uid=%s)(mail=%s))"
+			cf.AuthLDAP.Password = ""
+			cf.AuthLDAP.VerifyCert = false
+			cf.AuthLDAP.LS[0].ResponseTimeoutSec = 0
+		}, []string{
+			"auth_provider.ldap.pass",
+			"auth_provider.ldap.search_filter",
+		}, []string{
+			"auth_provider.ldap.servers[0].response_timeout_sec",
+			"auth_provider.ldap.verify_cert",
+		}},
+		{"auth check", func(cf *ConfigFile) {
+			cf.AuthCheck = &AuthCheck{Enable: true, JitterSec: -1, Servers: []AuthCheckServer{{Name: "server3"}}}
+		}, []string{
+			"auth_provider.auth_check.interval_sec",
+			"auth_provider.auth_check.jitter_sec",
+			"auth_provider.auth_check.servers[0].name",
+			"auth_provider.auth_check.user",
+		}, nil},
+		{"auth check without credentials", func(cf *ConfigFile) {
+			cf.AuthCheck = &AuthCheck{Enable: true, IntervalSec: 5, Method: globals.AuthCheckStatusServer}
+		}, nil, nil},
+		{"auth check credentials of one server", func(cf *ConfigFile) {
+			cf.AuthCheck = &AuthCheck{Enable: true, IntervalSec: 5, Method: globals.AuthCheckStatusServer, Servers: []AuthCheckServer{
+				{Name: "server2", Method: globals.AuthCheckCredentials},
+			}}
+		}, []string{"auth_provider.auth_check.user"}, nil},
+		{"auth check method of other provider", func(cf *ConfigFile) {
+			cf.AuthCheck = &AuthCheck{Enable: true, IntervalSec: 5, Method: globals.AuthCheckLDAPBind, Servers: []AuthCheckServer{
+				{Name: "server1", Method: globals.AuthCheckStatusServer},
+			}}
+		}, []string{"auth_provider.auth_check.method"}, nil},
+		{"failover and circuit breaker", func(cf *ConfigFile) {
+			cf.Failover = Failover{MaxAttempts: -1, DeadlineSec: -1}
+			cf.CircuitBreaker = Breaker{Enable: true}
+		}, []string{
+			"auth_provider.circuit_breaker.cooldown_sec",
+			"auth_provider.circuit_breaker.failure_threshold",
+			"auth_provider.failover.deadline_sec",
+			"auth_provider.failover.max_attempts",
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := validConfig()
+			tt.change(cf)
+			problems := cf.validate()
+			errs, warnings := paths(problems)
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("errors = %v, want %v", errs, tt.errs)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("warnings = %v, want %v", warnings, tt.warnings)
+			}
+			if hasErrors(problems) != (len(tt.errs) > 0) {
+				t.Errorf("hasErrors() = %v", hasErrors(problems))
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	cf := validConfig()
+	cf.L.Level = "trace"
+	cf.Srv.Port = 0
+	cf.AuthRadius.RS[1].Secret = ""
+	err := &ValidationError{Problems: cf.validate()}
+	want := "invalid config: web_server.port: port 0 is out of range 1-65535; auth_provider.radius.servers[1].secret: is empty"
+	if err.Error() != want {
+		t.Errorf("Error() = %s, want %s", err, want)
+	}
+	if strings.Contains(err.Error(), "log.level") {
+		t.Error("warnings are in error")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	problems, err := CheckConfig("../../config/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs, _ := paths(problems); len(errs) > 0 {
+		t.Errorf("example config has errors %v", problems)
+	}
+}
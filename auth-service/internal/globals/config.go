@@ -1,6 +1,11 @@
 package globals
 
-import "net"
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
 
 const (
 	AuthProviderRadius = "radius"
@@ -27,10 +32,16 @@ type IRadiusServersProvider interface {
 	AppLogger() AppLogger
 	RadiusServer(i int) (RadiusProvider, error)
 	NumAuthServers() int
-	GetAvailableRadiusAuthServer() (RadiusProvider, error)
+	GetAvailableRadiusAuthServer(user string, exclude []int) (int, RadiusProvider, func(error), error)
+	ChallengeServer(name, addr string) (RadiusProvider, func(error), bool)
+	AuthFailoverAttempts() int
+	AuthFailoverDeadline() time.Duration
 	NASID() string
 	NASIpV4Addr() net.IP
 	NASPort() uint32
+	ChallengeTimeoutSec() int
+	NumAccountingServers() int
+	GetAvailableAccountingServer(session string, exclude []int) (int, RadiusProvider, func(error), error)
 }
 
 type RadiusProvider interface {
@@ -45,22 +56,217 @@ type RadiusProvider interface {
 type LDAPServerProvider interface {
 	LDAPURL() string
 	GetUseSSL() bool
+	GetResponseTimeoutSec() int
+	GetName() string
 }
 
 type AuthClientProvider interface {
-	AuthenticateUser(user, pass, clientIp string) (bool, *NetworkData, error)
-	CheckAuthenticateUser(u, p string, serverIdx int) (bool, error)
+	AuthenticateUser(user, pass, clientIp string) (*AuthResult, error)
+	CheckAuthenticateUser(ctx context.Context, u, p string, serverIdx int) (bool, error)
+}
+
+// FailoverContext limits all attempts of one authentication request to servers by deadline.
+// Zero deadline means no limit
+func FailoverContext(deadline time.Duration) (context.Context, context.CancelFunc) {
+	if deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), deadline)
+}
+
+// methods of authentication server checks
+const (
+	// authentication of monitoring user
+	AuthCheckCredentials = "credentials"
+	// RADIUS Status-Server request
+	AuthCheckStatusServer = "status_server"
+	// LDAP bind with service account and reading of rootDSE
+	AuthCheckLDAPBind = "ldap_bind"
+)
+
+// ServerProber is implemented by auth clients which can check server without user credentials
+type ServerProber interface {
+	ProbeServer(ctx context.Context, serverIdx int) error
+}
+
+// ChallengeResponder is implemented by auth clients which can continue
+// authentication after the server answered with a challenge
+type ChallengeResponder interface {
+	ContinueAuthentication(state, user, response, clientIp string) (*AuthResult, error)
+}
+
+// AuthResult is decision of auth client. It is returned together with error too,
+// so the caller knows which server rejected the user
+type AuthResult struct {
+	Accepted bool
+	// Server is name of server which made the decision. Empty if none of servers answered
+	Server  string
+	NetData *NetworkData
+}
+
+// AuthChallenge is returned as error when authentication server requires
+// additional response from user (OTP code, push confirmation etc.)
+type AuthChallenge struct {
+	State   string `json:"state"`
+	Message string `json:"reply_message,omitempty"`
+}
+
+func (ac *AuthChallenge) Error() string {
+	return "Authentication server requires response to challenge"
+}
+
+// AccountingProvider is implemented by auth clients which can send accounting records
+type AccountingProvider interface {
+	SendAccounting(rec *AccountingRecord) error
+}
+
+// outcomes of /auth requests in audit log and metrics
+const (
+	OutcomeAccept    = "accept"
+	OutcomeReject    = "reject"
+	OutcomeChallenge = "challenge"
+	OutcomeForbidden = "forbidden"
+	OutcomeLocked    = "locked"
+	OutcomeLimited   = "limited"
+	OutcomeError     = "error"
+)
+
+const (
+	AcctStatusStart   = "start"
+	AcctStatusInterim = "interim"
+	AcctStatusStop    = "stop"
+)
+
+// causes of termination of session sent with stop record as Acct-Terminate-Cause
+const (
+	AcctTerminateUserRequest    = "user_request"
+	AcctTerminateLostCarrier    = "lost_carrier"
+	AcctTerminateIdleTimeout    = "idle_timeout"
+	AcctTerminateSessionTimeout = "session_timeout"
+	AcctTerminateAdminReset     = "admin_reset"
+	AcctTerminateNASRequest     = "nas_request"
+	AcctTerminateNASReboot      = "nas_reboot"
+)
+
+// AcctTerminateCauses lists valid terminate causes of accounting record
+var AcctTerminateCauses = []string{
+	AcctTerminateUserRequest, AcctTerminateLostCarrier, AcctTerminateIdleTimeout, AcctTerminateSessionTimeout,
+	AcctTerminateAdminReset, AcctTerminateNASRequest, AcctTerminateNASReboot,
 }
 
+// AccountingRecord describes state of OpenVPN client session
+type AccountingRecord struct {
+	StatusType  string `json:"status_type"`
+	SessionID   string `json:"session_id"`
+	User        string `json:"u"`
+	ClientIP    string `json:"client_ip"`
+	FramedIP    string `json:"framed_ip,omitempty"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+	PacketsIn   uint32 `json:"packets_in"`
+	PacketsOut  uint32 `json:"packets_out"`
+	SessionTime uint32 `json:"session_time"`
+	// TerminateCause is set only in stop record. Acct-Terminate-Cause is not sent if it is empty
+	TerminateCause string `json:"terminate_cause,omitempty"`
+}
+
+// Validate returns error if record can't be sent to accounting servers
+func (r *AccountingRecord) Validate() error {
+	switch r.StatusType {
+	case AcctStatusStart, AcctStatusInterim, AcctStatusStop:
+	default:
+		return fmt.Errorf("unsupported accounting status type %q", r.StatusType)
+	}
+	if r.FramedIP != "" && net.ParseIP(r.FramedIP) == nil {
+		return fmt.Errorf("invalid framed ip %q", r.FramedIP)
+	}
+	if r.TerminateCause != "" {
+		if r.StatusType != AcctStatusStop {
+			return fmt.Errorf("terminate cause is allowed only in %s record", AcctStatusStop)
+		}
+		valid := false
+		for _, c := range AcctTerminateCauses {
+			valid = valid || c == r.TerminateCause
+		}
+		if !valid {
+			return fmt.Errorf("unsupported terminate cause %q", r.TerminateCause)
+		}
+	}
+	return nil
+}
+
+// NetworkData carries the options from RADIUS reply which are pushed to OpenVPN client
 type NetworkData struct {
 	IP      string `json:"ip,omitempty"`
 	Netmask string `json:"netmask,omitempty"`
+	// every route is in format "network netmask"
+	Routes         []string `json:"routes,omitempty"`
+	DNSServers     []string `json:"dns_servers,omitempty"`
+	SessionTimeout uint32   `json:"session_timeout,omitempty"`
+	IdleTimeout    uint32   `json:"idle_timeout,omitempty"`
+	Class          []string `json:"class,omitempty"`
+	ReplyMessage   string   `json:"reply_message,omitempty"`
 }
 
 type MonitoringStatusResponse struct {
-	ID   int    `json:"status_id"`
-	Text string `json:"status_text"`
-	Msg  string `json:"msg,omitempty"`
+	ID       int            `json:"status_id"`
+	Text     string         `json:"status_text"`
+	Msg      string         `json:"msg,omitempty"`
+	Provider string         `json:"provider,omitempty"`
+	Servers  []ServerStatus `json:"servers,omitempty"`
+	// AccountingServers is set when radius accounting is enabled
+	AccountingServers []ServerStatus `json:"accounting_servers,omitempty"`
+	// Degraded is set when degraded mode is enabled
+	Degraded *DegradedStatus `json:"degraded_mode,omitempty"`
+}
+
+// DegradedStatus describes logins verified locally while authentication servers were unavailable
+type DegradedStatus struct {
+	Enabled bool `json:"enabled"`
+	// Active is true when none of authentication servers is available
+	Active        bool       `json:"active"`
+	StoredLogins  int        `json:"stored_logins"`
+	Logins        uint64     `json:"logins"`
+	LastLogin     *time.Time `json:"last_login,omitempty"`
+	LastLoginUser string     `json:"last_login_user,omitempty"`
+}
+
+// states of authentication server in status report
+const (
+	ServerAvailable   = "available"
+	ServerUnavailable = "unavailable"
+	ServerCircuitOpen = "circuit_open"
+)
+
+type ServerStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	State   string `json:"state"`
+	// state of circuit breaker: closed, open, half_open
+	CircuitBreaker string `json:"circuit_breaker"`
+	// consecutive transport failures of user authentication requests
+	ConsecutiveFailures int                 `json:"consecutive_failures"`
+	LastCheck           *time.Time          `json:"last_check,omitempty"`
+	LastCheckError      string              `json:"last_check_error,omitempty"`
+	LastError           string              `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time          `json:"last_error_time,omitempty"`
+	Inflight            int64               `json:"inflight"`
+	LatencyMs           *LatencyPercentiles `json:"latency_ms,omitempty"`
+	Requests            RequestCounters     `json:"requests"`
+}
+
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// RequestCounters counts results of user authentication requests. Failed requests are transport failures
+type RequestCounters struct {
+	Total    uint64 `json:"total"`
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+	Failed   uint64 `json:"failed"`
 }
 
 const (
@@ -0,0 +1,57 @@
+package globals
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountingRecordValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  AccountingRecord
+		ok   bool
+	}{
+		{"start", AccountingRecord{StatusType: AcctStatusStart}, true},
+		{"interim", AccountingRecord{StatusType: AcctStatusInterim, FramedIP: "10.8.0.2"}, true},
+		{"stop", AccountingRecord{StatusType: AcctStatusStop}, true},
+		{"stop with terminate cause", AccountingRecord{StatusType: AcctStatusStop, TerminateCause: AcctTerminateIdleTimeout}, true},
+		{"unknown status type", AccountingRecord{StatusType: "update"}, false},
+		{"empty status type", AccountingRecord{}, false},
+		{"invalid framed ip", AccountingRecord{StatusType: AcctStatusStart, FramedIP: "10.8.0"}, false},
+		{"unknown terminate cause", AccountingRecord{StatusType: AcctStatusStop, TerminateCause: "timeout"}, false},
+		{"terminate cause of start", AccountingRecord{StatusType: AcctStatusStart, TerminateCause: AcctTerminateUserRequest}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rec.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFailoverContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		limited  bool
+	}{
+		{"no limit", 0, false},
+		{"negative means no limit", -time.Second, false},
+		{"deadline", time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			ctx, cancel := FailoverContext(tt.deadline)
+			defer cancel()
+			d, ok := ctx.Deadline()
+			if ok != tt.limited {
+				t.Fatalf("Deadline() set = %v, want %v", ok, tt.limited)
+			}
+			if ok && (d.Before(start.Add(tt.deadline)) || d.After(time.Now().Add(tt.deadline))) {
+				t.Errorf("Deadline() = %s, want %s after start", d, tt.deadline)
+			}
+		})
+	}
+}
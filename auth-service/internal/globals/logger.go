@@ -1,9 +1,24 @@
 package globals
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrAuthenticationFailed = errors.New("Authentication failed")
 
+// ErrChallengeExpired is returned when response to challenge comes with unknown or expired state
+var ErrChallengeExpired = fmt.Errorf("%w: challenge is unknown or expired", ErrAuthenticationFailed)
+
+// ErrServerUnreachable wraps transport errors (timeout, connection refused, malformed reply).
+// Request failed with this error can be retried on another server
+var ErrServerUnreachable = errors.New("Authentication server unreachable")
+
+// ErrServerTimeout wraps errors of requests which were not answered in time
+var ErrServerTimeout = fmt.Errorf("%w: timeout", ErrServerUnreachable)
+
+var ErrNoServersAvailable = errors.New("No servers available for authentication")
+
 //AppLogger describes the zap interface
 type AppLogger interface {
 	DPanic(args ...interface{})
@@ -2,8 +2,14 @@ package ldapc
 
 import (
 	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	ldap "github.com/go-ldap/ldap/v3"
 )
@@ -14,70 +20,88 @@ type ConfigProvider interface {
 	GetSearchFilter() string
 	GetBindUserDN() string
 	GetPassword() string
-	GetAvailableAuthLDAPServer() (globals.LDAPServerProvider, error)
+	GetAvailableAuthLDAPServer(user string, exclude []int) (int, globals.LDAPServerProvider, func(error), error)
+	AuthFailoverAttempts() int
+	AuthFailoverDeadline() time.Duration
+	AuthCheckCanaryUser() string
 	LDAPAuthServer(idx int) (globals.LDAPServerProvider, error)
 	AppLogger() globals.AppLogger
 }
 
+// LDAPAuthClient reads settings of ldap from config on every request, so they can be reloaded
 type LDAPAuthClient struct {
 	c ConfigProvider
-	// ldapURL      string
-	verifyCert bool
-	// useSSL       bool
-	searchBase   string
-	searchFilter string
-	bindDN       string
-	pass         string
-	tlsCfg       *tls.Config
-	l            globals.AppLogger
+	l globals.AppLogger
 }
 
 func NewClient(c ConfigProvider) *LDAPAuthClient {
-	var tlsCfg tls.Config
-	if c.GetLDAPVerifyCert() {
-		tlsCfg = tls.Config{InsecureSkipVerify: false}
-	} else {
-		tlsCfg = tls.Config{InsecureSkipVerify: true}
-	}
-
-	// filter := fmt.Sprintf("(CN=%s)", ldap.EscapeFilter(user))
 	return &LDAPAuthClient{
 		c: c,
-		// ldapURL:      c.URL(),
-		verifyCert: c.GetLDAPVerifyCert(),
-		// useSSL:       c.GetUseSSL(),
-		searchBase:   c.GetSearchBase(),
-		searchFilter: c.GetSearchFilter(),
-		bindDN:       c.GetBindUserDN(),
-		pass:         c.GetPassword(),
-		tlsCfg:       &tlsCfg,
-		l:            c.AppLogger(),
+		l: c.AppLogger(),
 	}
 }
 
-func (a *LDAPAuthClient) authenticate(login, pass string, ldapURL string, useSSL bool) (bool, error) {
-	var c *ldap.Conn
-	var err error
-	if useSSL {
-		a.l.Debugf("dial ldaps url: %s", ldapURL)
-		c, err = ldap.DialURL(ldapURL, ldap.DialWithTLSConfig(a.tlsCfg))
-	} else {
-		a.l.Debugf("dial ldap url: %s", ldapURL)
-		c, err = ldap.DialURL(ldapURL)
-	}
+// Transport errors are wrapped with globals.ErrServerUnreachable
+func (a *LDAPAuthClient) authenticate(ctx context.Context, login, pass string, srv globals.LDAPServerProvider) (bool, error) {
+	c, err := a.dial(ctx, srv)
 	if err != nil {
 		return false, err
 	}
 	defer c.Close()
 
-	err = c.Bind(a.bindDN, a.pass)
+	err = c.Bind(a.c.GetBindUserDN(), a.c.GetPassword())
+	if err != nil {
+		return false, fmt.Errorf("failed to bind dn. %w", transportError(err, srv))
+	}
+	sr, err := a.searchUser(c, login, srv)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.Bind(sr.Entries[0].DN, pass)
+	if err != nil {
+		return false, fmt.Errorf("failed to authenticate user with login %s. error: %w", login, transportError(err, srv))
+	}
+	return true, nil
+}
+
+// dial connects to server. Timeout of connection is the least of server response timeout and ctx deadline
+func (a *LDAPAuthClient) dial(ctx context.Context, srv globals.LDAPServerProvider) (*ldap.Conn, error) {
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s", globals.ErrServerTimeout, ctx.Err())
+	}
+	timeout := time.Duration(srv.GetResponseTimeoutSec()) * time.Second
+	if d, ok := ctx.Deadline(); ok && (timeout <= 0 || time.Until(d) < timeout) {
+		timeout = time.Until(d)
+	}
+	dialOpts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: timeout})}
+	ldapURL := srv.LDAPURL()
+	if srv.GetUseSSL() {
+		a.l.Debugf("dial ldaps url: %s", ldapURL)
+		dialOpts = append(dialOpts, ldap.DialWithTLSConfig(&tls.Config{InsecureSkipVerify: !a.c.GetLDAPVerifyCert()}))
+	} else {
+		a.l.Debugf("dial ldap url: %s", ldapURL)
+	}
+	c, err := ldap.DialURL(ldapURL, dialOpts...)
 	if err != nil {
-		return false, fmt.Errorf("failed to bind dn. %s", err)
+		if isTimeout(err) {
+			metrics.UpstreamTimeout(srv.GetName())
+			return nil, fmt.Errorf("%w: %s", globals.ErrServerTimeout, err)
+		}
+		return nil, fmt.Errorf("%w: %s", globals.ErrServerUnreachable, err)
+	}
+	if timeout > 0 {
+		c.SetTimeout(timeout)
 	}
-	filter := fmt.Sprintf(a.searchFilter, login)
+	return c, nil
+}
+
+// searchUser finds user by login with configured search filter. Result has at least one entry
+func (a *LDAPAuthClient) searchUser(c *ldap.Conn, login string, srv globals.LDAPServerProvider) (*ldap.SearchResult, error) {
+	filter := fmt.Sprintf(a.c.GetSearchFilter(), login)
 	a.l.Debugf("ldap search filter: %s", filter)
 	sr, err := c.Search(ldap.NewSearchRequest(
-		a.searchBase,
+		a.c.GetSearchBase(),
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		0,
@@ -88,33 +112,113 @@ func (a *LDAPAuthClient) authenticate(login, pass string, ldapURL string, useSSL
 		nil,
 	))
 	if err != nil {
-		return false, err
+		return nil, transportError(err, srv)
 	}
 	if len(sr.Entries) == 0 {
-		return false, fmt.Errorf("user with login %s not found", login)
+		return nil, fmt.Errorf("user with login %s not found", login)
 	}
+	return sr, nil
+}
 
-	err = c.Bind(sr.Entries[0].DN, pass)
+// ProbeServer binds with service account and reads rootDSE. If canary user is configured,
+// it is searched with configured search filter. Password of canary user is not needed
+func (a *LDAPAuthClient) ProbeServer(ctx context.Context, serverIdx int) error {
+	srv, err := a.c.LDAPAuthServer(serverIdx)
 	if err != nil {
-		return false, fmt.Errorf("failed to authenticate user with login %s. error: %s", login, err)
+		return err
 	}
-	return true, nil
-}
+	start := time.Now()
+	c, err := a.dial(ctx, srv)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
 
-func (a *LDAPAuthClient) AuthenticateUser(u, p, ip string) (bool, *globals.NetworkData, error) {
-	// authResult := false
-	srv, err := a.c.GetAvailableAuthLDAPServer()
+	err = c.Bind(a.c.GetBindUserDN(), a.c.GetPassword())
+	if err != nil {
+		return fmt.Errorf("failed to bind dn. %w", transportError(err, srv))
+	}
+	sr, err := c.Search(ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"namingContexts", "currentTime"},
+		nil,
+	))
 	if err != nil {
-		return false, nil, err
+		return fmt.Errorf("failed to read rootDSE. %w", transportError(err, srv))
+	}
+	if len(sr.Entries) == 0 {
+		return errors.New("rootDSE is empty")
+	}
+	if canary := a.c.AuthCheckCanaryUser(); canary != "" {
+		if _, err := a.searchUser(c, canary, srv); err != nil {
+			return err
+		}
+	}
+	a.l.Debugf("Probe of server %s took %s", srv.GetName(), time.Since(start))
+	return nil
+}
+
+// transportError marks network errors of ldap connection as globals.ErrServerUnreachable
+func transportError(err error, srv globals.LDAPServerProvider) error {
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		if isTimeout(err) {
+			metrics.UpstreamTimeout(srv.GetName())
+			return fmt.Errorf("%w: %s", globals.ErrServerTimeout, err)
+		}
+		return fmt.Errorf("%w: %s", globals.ErrServerUnreachable, err)
+	}
+	return err
+}
+
+// isTimeout reports if ldap request failed by dial or response timeout
+func isTimeout(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	// go-ldap reports response timeout as network error with text only
+	return strings.Contains(err.Error(), "timed out")
+}
+
+func (a *LDAPAuthClient) AuthenticateUser(u, p, ip string) (*globals.AuthResult, error) {
+	res := &globals.AuthResult{}
+	ctx, cancel := globals.FailoverContext(a.c.AuthFailoverDeadline())
+	defer cancel()
+	tried := make([]int, 0, 1)
+	var err error
+	for attempt := 0; attempt < a.c.AuthFailoverAttempts(); attempt++ {
+		idx, srv, done, err2 := a.c.GetAvailableAuthLDAPServer(u, tried)
+		if err2 != nil {
+			if err == nil {
+				err = err2
+			}
+			break
+		}
+		tried = append(tried, idx)
+		res.Accepted, err = a.authenticate(ctx, u, p, srv)
+		done(err)
+		if errors.Is(err, globals.ErrServerUnreachable) && ctx.Err() == nil {
+			a.l.Errorf("Server %s is unreachable. Trying next server for user %s. Error %s", srv.GetName(), u, err)
+			continue
+		}
+		if !errors.Is(err, globals.ErrServerUnreachable) {
+			res.Server = srv.GetName()
+		}
+		return res, err
 	}
-	authResult, err := a.authenticate(u, p, srv.LDAPURL(), srv.GetUseSSL())
-	return authResult, nil, err
+	return res, err
 }
 
-func (a *LDAPAuthClient) CheckAuthenticateUser(u, p string, serverIdx int) (bool, error) {
+func (a *LDAPAuthClient) CheckAuthenticateUser(ctx context.Context, u, p string, serverIdx int) (bool, error) {
 	srv, err := a.c.LDAPAuthServer(serverIdx)
 	if err != nil {
 		return false, err
 	}
-	return a.authenticate(u, p, srv.LDAPURL(), srv.GetUseSSL())
+	return a.authenticate(ctx, u, p, srv)
 }
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth_service"
+
+// results of requests to upstream servers
+const (
+	ResultAccepted = "accepted"
+	ResultRejected = "rejected"
+	ResultFailed   = "failed"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	authRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_requests_total",
+		Help:      "Number of authentication requests by outcome.",
+	}, []string{"outcome"})
+	authDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "auth_request_duration_seconds",
+		Help:      "Duration of authentication requests by outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 20, 30, 60},
+	}, []string{"outcome"})
+	authInflight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "auth_requests_in_flight",
+		Help:      "Number of authentication requests being processed.",
+	})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of requests to authentication servers by result.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 20, 30, 60},
+	}, []string{"server", "result"})
+	upstreamTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_timeouts_total",
+		Help:      "Number of requests to authentication servers finished by timeout.",
+	}, []string{"server"})
+	healthChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "health_checks_total",
+		Help:      "Number of periodic checks of authentication servers by result.",
+	}, []string{"server", "result"})
+	healthCheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "health_check_duration_seconds",
+		Help:      "Duration of periodic checks of authentication servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server"})
+	coalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_requests_coalesced_total",
+		Help:      "Number of authentication requests answered with result of identical concurrent request.",
+	})
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_cache_lookups_total",
+		Help:      "Number of lookups in cache of successful authentications by result.",
+	}, []string{"result"})
+	degradedLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "degraded_logins_total",
+		Help:      "Number of users accepted in degraded mode by source of verification.",
+	}, []string{"source"})
+	lockouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lockouts_total",
+		Help:      "Number of temporary lockouts after failed authentications by scope.",
+	}, []string{"scope"})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of config reloads by result.",
+	}, []string{"result"})
+	accountingRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accounting_requests_total",
+		Help:      "Number of accounting requests by status type and result.",
+	}, []string{"status_type", "result"})
+	signatureRejects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signature_rejects_total",
+		Help:      "Number of requests rejected by verification of request signature by reason.",
+	}, []string{"reason"})
+	syslogDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "syslog_dropped_total",
+		Help:      "Number of messages dropped because queue of syslog writer was full by sink.",
+	}, []string{"sink"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		authRequests,
+		authDuration,
+		authInflight,
+		upstreamDuration,
+		upstreamTimeouts,
+		healthChecks,
+		healthCheckDuration,
+		coalesced,
+		cacheLookups,
+		degradedLogins,
+		lockouts,
+		configReloads,
+		accountingRequests,
+		signatureRejects,
+		syslogDropped,
+	)
+}
+
+// Handler returns http handler exposing metrics in prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// AuthStarted marks start of /auth request. Returned function must be called with outcome of the request,
+// one of globals.Outcome*
+func AuthStarted() func(outcome string) {
+	authInflight.Inc()
+	start := time.Now()
+	return func(outcome string) {
+		authInflight.Dec()
+		authRequests.WithLabelValues(outcome).Inc()
+		authDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+func ObserveUpstream(server, result string, d time.Duration) {
+	upstreamDuration.WithLabelValues(server, result).Observe(d.Seconds())
+}
+
+func UpstreamTimeout(server string) {
+	upstreamTimeouts.WithLabelValues(server).Inc()
+}
+
+func ObserveHealthCheck(server string, ok bool, d time.Duration) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	healthChecks.WithLabelValues(server, result).Inc()
+	healthCheckDuration.WithLabelValues(server).Observe(d.Seconds())
+}
+
+func Accounting(statusType string, ok bool) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	accountingRequests.WithLabelValues(statusType, result).Inc()
+}
+
+func Coalesced() {
+	coalesced.Inc()
+}
+
+func CacheLookup(hit bool) {
+	result := "hit"
+	if !hit {
+		result = "miss"
+	}
+	cacheLookups.WithLabelValues(result).Inc()
+}
+
+func DegradedLogin(source string) {
+	degradedLogins.WithLabelValues(source).Inc()
+}
+
+func Lockout(scope string) {
+	lockouts.WithLabelValues(scope).Inc()
+}
+
+func ConfigReload(ok bool) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	configReloads.WithLabelValues(result).Inc()
+}
+
+func SignatureRejected(reason string) {
+	signatureRejects.WithLabelValues(reason).Inc()
+}
+
+// SyslogDropped counts message of sink log or audit dropped by syslog writer
+func SyslogDropped(sink string) {
+	syslogDropped.WithLabelValues(sink).Inc()
+}
+
+// RegisterServersState exposes number of available and unavailable authentication servers.
+// f is called on every scrape
+func RegisterServersState(f func() (available, unavailable int)) {
+	registry.MustRegister(&serversCollector{f: f})
+}
+
+type serversCollector struct {
+	f func() (int, int)
+}
+
+var serversDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "servers"),
+	"Number of authentication servers by state.",
+	[]string{"state"}, nil,
+)
+
+func (sc *serversCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serversDesc
+}
+
+func (sc *serversCollector) Collect(ch chan<- prometheus.Metric) {
+	available, unavailable := sc.f()
+	ch <- prometheus.MustNewConstMetric(serversDesc, prometheus.GaugeValue, float64(available), "available")
+	ch <- prometheus.MustNewConstMetric(serversDesc, prometheus.GaugeValue, float64(unavailable), "unavailable")
+}
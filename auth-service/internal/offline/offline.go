@@ -0,0 +1,225 @@
+package offline
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/pwhash"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sources of degraded mode decisions
+const (
+	SourceOfflineCache = "offline_cache"
+	SourceBreakGlass   = "break_glass"
+)
+
+var (
+	ErrUnknownUser = errors.New("degraded mode: user has no recent successful login")
+	ErrExpired     = errors.New("degraded mode: last successful login of user is too old")
+	ErrPassword    = errors.New("degraded mode: password doesn't match")
+)
+
+// Config of degraded mode. BreakGlass maps user to password hash created by pwhash.Hash
+type Config struct {
+	StoreFile    string
+	MaxAge       time.Duration
+	ExcludeUsers []string
+	BreakGlass   map[string]string
+}
+
+type login struct {
+	Hash      string               `json:"hash"`
+	LastLogin time.Time            `json:"last_login"`
+	NetData   *globals.NetworkData `json:"net_data,omitempty"`
+}
+
+// Authenticator verifies users when none of authentication servers is available.
+// It keeps salted password hashes of recent successful logins in file
+type Authenticator struct {
+	cfg     Config
+	l       globals.AppLogger
+	exclude map[string]bool
+	// break-glass accounts by lower case user
+	breakGlass map[string]string
+	m          sync.Mutex
+	logins     map[string]*login
+	// saving of file is serialized
+	saveM sync.Mutex
+
+	lastUser  string
+	lastLogin time.Time
+	count     uint64
+}
+
+func New(cfg Config, l globals.AppLogger) (*Authenticator, error) {
+	a := &Authenticator{
+		cfg:        cfg,
+		l:          l,
+		exclude:    make(map[string]bool),
+		breakGlass: make(map[string]string, len(cfg.BreakGlass)),
+		logins:     make(map[string]*login),
+	}
+	for _, u := range cfg.ExcludeUsers {
+		a.exclude[strings.ToLower(u)] = true
+	}
+	for u, hash := range cfg.BreakGlass {
+		a.breakGlass[strings.ToLower(u)] = hash
+	}
+	b, err := ioutil.ReadFile(cfg.StoreFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &a.logins); err != nil {
+			return nil, err
+		}
+	}
+	for u := range a.logins {
+		if a.exclude[u] {
+			delete(a.logins, u)
+		}
+	}
+	return a, nil
+}
+
+// Remember stores hash of password after successful login of user. It is slow and may be run in goroutine
+func (a *Authenticator) Remember(user, pass string, nd *globals.NetworkData) {
+	user = strings.ToLower(user)
+	if a.exclude[user] {
+		return
+	}
+	hash, err := pwhash.Hash(pass, pwhash.DefaultIterations)
+	if err != nil {
+		a.l.Error(err)
+		return
+	}
+	a.m.Lock()
+	a.logins[user] = &login{Hash: hash, LastLogin: time.Now(), NetData: nd}
+	a.m.Unlock()
+	a.save()
+}
+
+// Forget removes stored login of user when authentication server rejects pass which matches the
+// stored hash, e.g. after the password was changed or the account was disabled. Rejects of other
+// passwords keep the login, so wrong passwords sent by anyone can't wipe it. It is slow and may be run in goroutine
+func (a *Authenticator) Forget(user, pass string) {
+	user = strings.ToLower(user)
+	a.m.Lock()
+	lg, ok := a.logins[user]
+	a.m.Unlock()
+	if !ok || verify(pass, lg.Hash) != nil {
+		return
+	}
+	a.m.Lock()
+	// login may be replaced while hash was verified
+	if a.logins[user] != lg {
+		a.m.Unlock()
+		return
+	}
+	delete(a.logins, user)
+	a.m.Unlock()
+	a.save()
+}
+
+// Authenticate verifies user by break-glass account list or by stored recent login.
+// Returned result has source of decision as server name
+func (a *Authenticator) Authenticate(user, pass string) (*globals.AuthResult, error) {
+	res := &globals.AuthResult{}
+	if hash, ok := a.breakGlass[strings.ToLower(user)]; ok {
+		res.Server = SourceBreakGlass
+		if err := verify(pass, hash); err != nil {
+			return res, err
+		}
+		res.Accepted = true
+		a.accepted(user)
+		return res, nil
+	}
+	res.Server = SourceOfflineCache
+	a.m.Lock()
+	lg, ok := a.logins[strings.ToLower(user)]
+	a.m.Unlock()
+	if !ok {
+		return res, ErrUnknownUser
+	}
+	if a.cfg.MaxAge > 0 && time.Since(lg.LastLogin) > a.cfg.MaxAge {
+		return res, ErrExpired
+	}
+	if err := verify(pass, lg.Hash); err != nil {
+		return res, err
+	}
+	res.Accepted = true
+	res.NetData = lg.NetData
+	a.accepted(user)
+	return res, nil
+}
+
+// Status returns statistics of logins in degraded mode
+func (a *Authenticator) Status() *globals.DegradedStatus {
+	a.m.Lock()
+	defer a.m.Unlock()
+	st := &globals.DegradedStatus{
+		Enabled:       true,
+		StoredLogins:  len(a.logins),
+		Logins:        a.count,
+		LastLoginUser: a.lastUser,
+	}
+	if !a.lastLogin.IsZero() {
+		t := a.lastLogin
+		st.LastLogin = &t
+	}
+	return st
+}
+
+func (a *Authenticator) accepted(user string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.count++
+	a.lastUser = user
+	a.lastLogin = time.Now()
+}
+
+// save writes logins to temporary file and renames it, so the store is never partially written
+func (a *Authenticator) save() {
+	a.saveM.Lock()
+	defer a.saveM.Unlock()
+	a.m.Lock()
+	b, err := json.Marshal(a.logins)
+	a.m.Unlock()
+	if err != nil {
+		a.l.Error(err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(a.cfg.StoreFile), ".offline-*")
+	if err != nil {
+		a.l.Errorf("Unable to save degraded mode store. Error %s", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), a.cfg.StoreFile)
+	}
+	if err != nil {
+		a.l.Errorf("Unable to save degraded mode store. Error %s", err)
+	}
+}
+
+func verify(pass, hash string) error {
+	ok, err := pwhash.Verify(pass, hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPassword
+	}
+	return nil
+}
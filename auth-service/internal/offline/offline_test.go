@@ -0,0 +1,113 @@
+package offline
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/pwhash"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T, cfg Config) *Authenticator {
+	t.Helper()
+	if cfg.StoreFile == "" {
+		cfg.StoreFile = filepath.Join(t.TempDir(), "offline.json")
+	}
+	a, err := New(cfg, &globals.DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := pwhash.Hash("glass", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuthenticator(t, Config{
+		MaxAge:       time.Hour,
+		ExcludeUsers: []string{"CEO"},
+		BreakGlass:   map[string]string{"Admin": hash},
+	})
+	nd := &globals.NetworkData{IP: "10.8.0.2"}
+	a.Remember("user", "pass", nd)
+	a.Remember("old", "pass", nil)
+	a.logins["old"].LastLogin = time.Now().Add(-time.Hour - time.Minute)
+	a.Remember("ceo", "pass", nil)
+	tests := []struct {
+		name     string
+		user     string
+		pass     string
+		accepted bool
+		server   string
+		err      error
+	}{
+		{"stored login", "user", "pass", true, SourceOfflineCache, nil},
+		{"user name case", "USER", "pass", true, SourceOfflineCache, nil},
+		{"wrong password", "user", "wrong", false, SourceOfflineCache, ErrPassword},
+		{"unknown user", "other", "pass", false, SourceOfflineCache, ErrUnknownUser},
+		{"login older than max age", "old", "pass", false, SourceOfflineCache, ErrExpired},
+		{"excluded user", "ceo", "pass", false, SourceOfflineCache, ErrUnknownUser},
+		{"break-glass", "admin", "glass", true, SourceBreakGlass, nil},
+		{"break-glass user name case", "ADMIN", "glass", true, SourceBreakGlass, nil},
+		{"break-glass wrong password", "admin", "pass", false, SourceBreakGlass, ErrPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := a.Authenticate(tt.user, tt.pass)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.err)
+			}
+			if res.Accepted != tt.accepted || res.Server != tt.server {
+				t.Errorf("Authenticate() = %v %s, want %v %s", res.Accepted, res.Server, tt.accepted, tt.server)
+			}
+		})
+	}
+	if res, _ := a.Authenticate("user", "pass"); res.NetData != nd {
+		t.Errorf("NetData = %v, want %v", res.NetData, nd)
+	}
+	if st := a.Status(); st.Logins != 5 || st.StoredLogins != 2 {
+		t.Errorf("Status() = %d logins, %d stored, want 5, 2", st.Logins, st.StoredLogins)
+	}
+}
+
+func TestForget(t *testing.T) {
+	tests := []struct {
+		name string
+		// password rejected by authentication server
+		pass string
+		kept bool
+	}{
+		{"stored password is rejected", "pass", false},
+		{"other password is rejected", "wrong", true},
+		{"empty password is rejected", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthenticator(t, Config{})
+			a.Remember("user", "pass", nil)
+			a.Forget("User", tt.pass)
+			if _, err := a.Authenticate("user", "pass"); (err == nil) != tt.kept {
+				t.Errorf("Authenticate() error = %v, want login kept %v", err, tt.kept)
+			}
+		})
+	}
+}
+
+func TestStoreFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "offline.json")
+	a := newTestAuthenticator(t, Config{StoreFile: file})
+	a.Remember("user", "pass", nil)
+	a.Remember("ceo", "pass", nil)
+
+	// users excluded after restart are removed from the store
+	b := newTestAuthenticator(t, Config{StoreFile: file, ExcludeUsers: []string{"ceo"}})
+	if res, err := b.Authenticate("user", "pass"); err != nil || !res.Accepted {
+		t.Errorf("Authenticate() = %v, %v after restart", res.Accepted, err)
+	}
+	if _, err := b.Authenticate("ceo", "pass"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Authenticate() of excluded user error = %v, want %v", err, ErrUnknownUser)
+	}
+}
@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// probe request in half open state is considered lost after defaultProbeTimeout
+// if ProbeTimeout is not set
+const defaultProbeTimeout = 30 * time.Second
+
+// BreakerConfig configures circuit breaker of every server.
+// Breaker opens after Threshold consecutive transport failures, lets one request through
+// after Cooldown and closes on its success. If result of that request is not reported
+// in ProbeTimeout, the next request is let through
+type BreakerConfig struct {
+	Enable       bool
+	Threshold    int
+	Cooldown     time.Duration
+	ProbeTimeout time.Duration
+}
+
+type breaker struct {
+	cfg      BreakerConfig
+	m        sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// request in half open state is in progress since probeStart
+	probing    bool
+	probeStart time.Time
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 1
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = defaultProbeTimeout
+	}
+	return &breaker{cfg: cfg, state: BreakerClosed}
+}
+
+// usable reports if request can be sent to server now
+func (b *breaker) usable(now time.Time) bool {
+	if !b.cfg.Enable {
+		return true
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) >= b.cfg.Cooldown
+	case BreakerHalfOpen:
+		return !b.probing || b.probeLost(now)
+	}
+	return true
+}
+
+// probeLost reports if result of probe request was not reported in time, e.g. caller
+// returned before sending the request. Must be called with locked mutex
+func (b *breaker) probeLost(now time.Time) bool {
+	return now.Sub(b.probeStart) >= b.cfg.ProbeTimeout
+}
+
+// acquire is called for the chosen server. Open breaker after cooldown becomes half open
+func (b *breaker) acquire(now time.Time) {
+	if !b.cfg.Enable {
+		return
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.cfg.Cooldown {
+		b.state = BreakerHalfOpen
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing = true
+		b.probeStart = now
+	}
+}
+
+// report records result of request and returns previous and new state
+func (b *breaker) report(failed bool, now time.Time) (string, string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	prev := b.state
+	if !failed {
+		b.failures = 0
+		b.probing = false
+		b.state = BreakerClosed
+		return prev, b.state
+	}
+	b.failures++
+	if !b.cfg.Enable {
+		return prev, prev
+	}
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.probing = false
+	}
+	return prev, b.state
+}
+
+// inherit copies state of old breaker. Config of b is kept
+func (b *breaker) inherit(old *breaker) {
+	old.m.Lock()
+	defer old.m.Unlock()
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.state = old.state
+	b.failures = old.failures
+	b.openedAt = old.openedAt
+	b.probing = old.probing
+	b.probeStart = old.probeStart
+	if !b.cfg.Enable {
+		b.state = BreakerClosed
+		b.probing = false
+	}
+}
+
+func (b *breaker) snapshot() (string, int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.state, b.failures
+}
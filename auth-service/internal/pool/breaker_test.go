@@ -0,0 +1,129 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+// operations of breaker steps
+const (
+	opAcquire = "acquire"
+	opFail    = "fail"
+	opSucceed = "succeed"
+	opInherit = "inherit"
+	// only checks state at the time of step
+	opWait = "wait"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	cfg := BreakerConfig{Enable: true, Threshold: 2, Cooldown: 10 * time.Second, ProbeTimeout: 5 * time.Second}
+	type step struct {
+		op string
+		// seconds since start
+		at     int
+		state  string
+		usable bool
+	}
+	tests := []struct {
+		name  string
+		cfg   BreakerConfig
+		steps []step
+	}{
+		{"failures below threshold", cfg, []step{
+			{opAcquire, 0, BreakerClosed, true},
+			{opFail, 0, BreakerClosed, true},
+		}},
+		{"success resets failures", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opSucceed, 0, BreakerClosed, true},
+			{opFail, 0, BreakerClosed, true},
+		}},
+		{"threshold opens", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 1, BreakerOpen, false},
+			{opWait, 10, BreakerOpen, false},
+			{opWait, 11, BreakerOpen, true},
+		}},
+		{"probe after cooldown", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 0, BreakerOpen, false},
+			{opAcquire, 10, BreakerHalfOpen, false},
+			{opSucceed, 11, BreakerClosed, true},
+		}},
+		{"failed probe opens again", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 0, BreakerOpen, false},
+			{opAcquire, 10, BreakerHalfOpen, false},
+			{opFail, 11, BreakerOpen, false},
+			{opWait, 20, BreakerOpen, false},
+			{opAcquire, 21, BreakerHalfOpen, false},
+		}},
+		{"lost probe lets next request through", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 0, BreakerOpen, false},
+			{opAcquire, 10, BreakerHalfOpen, false},
+			{opWait, 14, BreakerHalfOpen, false},
+			{opWait, 15, BreakerHalfOpen, true},
+			{opAcquire, 15, BreakerHalfOpen, false},
+			{opWait, 19, BreakerHalfOpen, false},
+			{opSucceed, 20, BreakerClosed, true},
+		}},
+		{"inherited probe stays in progress", cfg, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 0, BreakerOpen, false},
+			{opAcquire, 10, BreakerHalfOpen, false},
+			{opInherit, 11, BreakerHalfOpen, false},
+			{opWait, 15, BreakerHalfOpen, true},
+			{opSucceed, 16, BreakerClosed, true},
+		}},
+		{"disabled", BreakerConfig{Threshold: 1}, []step{
+			{opFail, 0, BreakerClosed, true},
+			{opFail, 0, BreakerClosed, true},
+			{opAcquire, 0, BreakerClosed, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Unix(1700000000, 0)
+			b := newBreaker(tt.cfg)
+			for i, st := range tt.steps {
+				now := start.Add(time.Duration(st.at) * time.Second)
+				switch st.op {
+				case opAcquire:
+					if !b.usable(now) {
+						t.Fatalf("step %d: breaker is not usable for acquire", i)
+					}
+					b.acquire(now)
+				case opFail:
+					b.report(true, now)
+				case opSucceed:
+					b.report(false, now)
+				case opInherit:
+					next := newBreaker(tt.cfg)
+					next.inherit(b)
+					b = next
+				}
+				if state, _ := b.snapshot(); state != st.state {
+					t.Errorf("step %d %s: state = %s, want %s", i, st.op, state, st.state)
+				}
+				if usable := b.usable(now); usable != st.usable {
+					t.Errorf("step %d %s: usable = %v, want %v", i, st.op, usable, st.usable)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerInheritDisabled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	old := newBreaker(BreakerConfig{Enable: true, Threshold: 1, Cooldown: time.Minute})
+	old.report(true, now)
+	b := newBreaker(BreakerConfig{Threshold: 1})
+	b.inherit(old)
+	if state, failures := b.snapshot(); state != BreakerClosed || failures != 1 {
+		t.Errorf("snapshot() = %s, %d, want %s, 1", state, failures, BreakerClosed)
+	}
+	if !b.usable(now) {
+		t.Error("disabled breaker is not usable")
+	}
+}
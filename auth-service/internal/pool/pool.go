@@ -0,0 +1,277 @@
+package pool
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoServers = globals.ErrNoServersAvailable
+
+// weight of the last request in observed latency
+const latencyEWMAWeight = 0.3
+
+// ServerOptions describes authentication server from config
+type ServerOptions struct {
+	Name   string
+	Weight int
+}
+
+// Server keeps the load and latency of one authentication server
+type Server struct {
+	idx      int
+	name     string
+	weight   int
+	inflight int64
+	p        *Pool
+	m        sync.Mutex
+	latency  time.Duration
+	// server of reloaded pool which replaced this one
+	next    *Server
+	breaker *breaker
+	stats   *stats
+}
+
+func (s *Server) Index() int {
+	return s.idx
+}
+
+func (s *Server) Name() string {
+	return s.name
+}
+
+func (s *Server) Weight() int {
+	return s.weight
+}
+
+// Inflight returns number of requests which are being processed by server now
+func (s *Server) Inflight() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// Latency returns exponentially weighted moving average of response time.
+// Zero means the server has not been used yet
+func (s *Server) Latency() time.Duration {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.latency
+}
+
+// current returns server which replaced s after reloads or s itself if it is not replaced
+func (s *Server) current() *Server {
+	for {
+		s.m.Lock()
+		next := s.next
+		s.m.Unlock()
+		if next == nil {
+			return s
+		}
+		s = next
+	}
+}
+
+func (s *Server) observe(d time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.latency == 0 {
+		s.latency = d
+		return
+	}
+	s.latency = time.Duration(latencyEWMAWeight*float64(d) + (1-latencyEWMAWeight)*float64(s.latency))
+}
+
+// BreakerState returns state of circuit breaker and number of consecutive transport failures
+func (s *Server) BreakerState() (string, int) {
+	return s.breaker.snapshot()
+}
+
+// Status returns statistics of server. Name, address and state are set by caller
+func (s *Server) Status() *globals.ServerStatus {
+	ss := &globals.ServerStatus{Inflight: s.Inflight()}
+	ss.CircuitBreaker, ss.ConsecutiveFailures = s.breaker.snapshot()
+	s.stats.fill(ss)
+	return ss
+}
+
+// Pool chooses authentication server for every request according to strategy
+type Pool struct {
+	strategy Strategy
+	servers  []*Server
+	// serializes choice of server, so only one request is sent to half open server
+	m sync.Mutex
+	l globals.AppLogger
+	// called on every transport failure
+	onFailure func(idx int)
+}
+
+// New creates pool of servers. Index of server in slice is the index of server in config
+func New(strategy string, servers []ServerOptions, bc BreakerConfig) (*Pool, error) {
+	st, err := NewStrategy(strategy)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pool{
+		strategy: st,
+		servers:  make([]*Server, len(servers)),
+		l:        &globals.DummyLogger{},
+	}
+	for i, so := range servers {
+		w := so.Weight
+		if w <= 0 {
+			w = 1
+		}
+		p.servers[i] = &Server{idx: i, name: so.Name, weight: w, p: p, breaker: newBreaker(bc), stats: newStats()}
+	}
+	return p, nil
+}
+
+// Inherit takes latency, statistics and circuit breaker state of servers from old pool.
+// from[i] is index of server i in old pool or -1 for new server. Results of requests
+// acquired from old pool which finish later are reported to servers of p.
+// Results of removed servers are dropped
+func (p *Pool) Inherit(old *Pool, from []int) {
+	for i, j := range from {
+		if i >= len(p.servers) || j < 0 || j >= len(old.servers) {
+			continue
+		}
+		s, o := p.servers[i], old.servers[j]
+		s.latency = o.Latency()
+		s.stats = o.stats
+		s.breaker.inherit(o.breaker)
+		o.m.Lock()
+		o.next = s
+		o.m.Unlock()
+	}
+}
+
+func (p *Pool) SetLogger(l globals.AppLogger) {
+	p.l = l
+}
+
+func (p *Pool) SetFailureListener(f func(idx int)) {
+	p.onFailure = f
+}
+
+// Server returns state of server by index
+func (p *Pool) Server(idx int) *Server {
+	return p.servers[idx]
+}
+
+// Pick returns index of server chosen from available servers except excluded ones
+// and servers with open circuit breaker. Key is used by sticky strategy
+func (p *Pool) Pick(available []int, key string, exclude []int) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	now := time.Now()
+	candidates := make([]*Server, 0, len(available))
+	for _, i := range available {
+		if i < 0 || i >= len(p.servers) {
+			return 0, fmt.Errorf("server index %d is out of range", i)
+		}
+		if contains(exclude, i) || !p.servers[i].breaker.usable(now) {
+			continue
+		}
+		candidates = append(candidates, p.servers[i])
+	}
+	if len(candidates) == 0 {
+		return 0, ErrNoServers
+	}
+	s := p.strategy.Select(candidates, key)
+	s.breaker.acquire(now)
+	return s.Index(), nil
+}
+
+// Acquire marks start of request to server. Returned function must be called with the result
+// of request when it is finished. Errors wrapping globals.ErrServerUnreachable are counted
+// as failures of server, other results mean the server is alive. *globals.AuthChallenge
+// is counted as accepted request
+func (p *Pool) Acquire(idx int) func(err error) {
+	return p.acquire(idx, true)
+}
+
+// AcquireChallengeResponse is Acquire for response of user to challenge. The server is chosen
+// by challenge, and response time is not used for latency
+func (p *Pool) AcquireChallengeResponse(idx int) func(err error) {
+	return p.acquire(idx, false)
+}
+
+func (p *Pool) acquire(idx int, observeLatency bool) func(err error) {
+	s := p.servers[idx]
+	atomic.AddInt64(&s.inflight, 1)
+	start := time.Now()
+	return func(err error) {
+		atomic.AddInt64(&s.inflight, -1)
+		// config might be reloaded while request was in progress
+		s := s.current()
+		now := time.Now()
+		failed := errors.Is(err, globals.ErrServerUnreachable)
+		challenge := isChallenge(err)
+		if challenge {
+			// challenge means the server has accepted the first factor
+			err = nil
+		}
+		// only final answers are used for latency, so timeouts and time of user
+		// answering MFA don't make the server look slow
+		final := !failed && !challenge && observeLatency
+		if final {
+			s.observe(now.Sub(start))
+		}
+		s.stats.request(now.Sub(start), err, final, now)
+		metrics.ObserveUpstream(s.name, requestResult(err), now.Sub(start))
+		prev, state := s.breaker.report(failed, now)
+		if prev != state {
+			s.p.l.Errorf("Circuit breaker of server %s changed state from %s to %s", s.name, prev, state)
+		}
+		if failed && s.p.onFailure != nil {
+			s.p.onFailure(s.idx)
+		}
+	}
+}
+
+func isChallenge(err error) bool {
+	var ch *globals.AuthChallenge
+	return errors.As(err, &ch)
+}
+
+func requestResult(err error) string {
+	switch {
+	case err == nil:
+		return metrics.ResultAccepted
+	case errors.Is(err, globals.ErrServerUnreachable):
+		return metrics.ResultFailed
+	}
+	return metrics.ResultRejected
+}
+
+// ReportCheck records result of periodic check of server
+func (p *Pool) ReportCheck(idx int, err error) {
+	if idx < 0 || idx >= len(p.servers) {
+		return
+	}
+	p.servers[idx].stats.check(err, time.Now())
+}
+
+// Usable returns indexes of servers from available with not open circuit breaker
+func (p *Pool) Usable(available []int) []int {
+	now := time.Now()
+	usable := make([]int, 0, len(available))
+	for _, i := range available {
+		if i >= 0 && i < len(p.servers) && p.servers[i].breaker.usable(now) {
+			usable = append(usable, i)
+		}
+	}
+	return usable
+}
+
+func contains(arr []int, v int) bool {
+	for _, x := range arr {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package pool
+
+import (
+	"auth-service/internal/globals"
+	"fmt"
+	"testing"
+)
+
+func TestAcquireStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge bool
+		err       error
+		samples   int
+		accepted  uint64
+		rejected  uint64
+		failed    uint64
+	}{
+		{"accept", false, nil, 1, 1, 0, 0},
+		{"reject", false, globals.ErrAuthenticationFailed, 1, 0, 1, 0},
+		{"timeout", false, fmt.Errorf("%w: deadline", globals.ErrServerTimeout), 0, 0, 0, 1},
+		{"unreachable", false, fmt.Errorf("%w: refused", globals.ErrServerUnreachable), 0, 0, 0, 1},
+		{"challenge", false, &globals.AuthChallenge{State: "s"}, 0, 1, 0, 0},
+		{"response to challenge", true, nil, 0, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(StrategyFailover, []ServerOptions{{Name: "r1"}}, BreakerConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := p.Acquire(0)
+			if tt.challenge {
+				done = p.AcquireChallengeResponse(0)
+			}
+			done(tt.err)
+			st := p.Server(0).stats
+			if len(st.samples) != tt.samples {
+				t.Errorf("%d latency samples, want %d", len(st.samples), tt.samples)
+			}
+			if st.accepted != tt.accepted || st.rejected != tt.rejected || st.failed != tt.failed {
+				t.Errorf("requests = %d accepted, %d rejected, %d failed, want %d, %d, %d",
+					st.accepted, st.rejected, st.failed, tt.accepted, tt.rejected, tt.failed)
+			}
+			ss := &globals.ServerStatus{}
+			st.fill(ss)
+			if (ss.LatencyMs != nil) != (tt.samples > 0) {
+				t.Errorf("latency = %+v, want it reported %v", ss.LatencyMs, tt.samples > 0)
+			}
+		})
+	}
+}
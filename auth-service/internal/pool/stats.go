@@ -0,0 +1,107 @@
+package pool
+
+import (
+	"auth-service/internal/globals"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// number of the last requests used for latency percentiles
+const latencySamples = 512
+
+// stats keeps results of requests and checks of server
+type stats struct {
+	m        sync.Mutex
+	samples  []time.Duration
+	next     int
+	accepted uint64
+	rejected uint64
+	failed   uint64
+
+	lastCheck      time.Time
+	lastCheckError string
+	lastError      string
+	lastErrorTime  time.Time
+}
+
+func newStats() *stats {
+	return &stats{samples: make([]time.Duration, 0, latencySamples)}
+}
+
+// request records result of user authentication request. Errors other than
+// globals.ErrServerUnreachable mean the server has rejected credentials.
+// d is used for latency percentiles only if sample is set
+func (st *stats) request(d time.Duration, err error, sample bool, now time.Time) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	if sample {
+		if len(st.samples) < latencySamples {
+			st.samples = append(st.samples, d)
+		} else {
+			st.samples[st.next] = d
+		}
+		st.next = (st.next + 1) % latencySamples
+	}
+	switch {
+	case err == nil:
+		st.accepted++
+	case errors.Is(err, globals.ErrServerUnreachable):
+		st.failed++
+		st.lastError = err.Error()
+		st.lastErrorTime = now
+	default:
+		st.rejected++
+	}
+}
+
+func (st *stats) check(err error, now time.Time) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	st.lastCheck = now
+	st.lastCheckError = ""
+	if err != nil {
+		st.lastCheckError = err.Error()
+		st.lastError = err.Error()
+		st.lastErrorTime = now
+	}
+}
+
+// fill sets statistics fields of server status
+func (st *stats) fill(ss *globals.ServerStatus) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	ss.Requests = globals.RequestCounters{
+		Total:    st.accepted + st.rejected + st.failed,
+		Accepted: st.accepted,
+		Rejected: st.rejected,
+		Failed:   st.failed,
+	}
+	if !st.lastCheck.IsZero() {
+		t := st.lastCheck
+		ss.LastCheck = &t
+		ss.LastCheckError = st.lastCheckError
+	}
+	if !st.lastErrorTime.IsZero() {
+		t := st.lastErrorTime
+		ss.LastErrorTime = &t
+		ss.LastError = st.lastError
+	}
+	if len(st.samples) == 0 {
+		return
+	}
+	sorted := make([]time.Duration, len(st.samples))
+	copy(sorted, st.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ss.LatencyMs = &globals.LatencyPercentiles{
+		P50: percentileMs(sorted, 0.5),
+		P90: percentileMs(sorted, 0.9),
+		P99: percentileMs(sorted, 0.99),
+	}
+}
+
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
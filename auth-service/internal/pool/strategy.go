@@ -0,0 +1,122 @@
+package pool
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	StrategyFailover      = "failover"
+	StrategyRoundRobin    = "round_robin"
+	StrategyWeighted      = "weighted"
+	StrategyLeastRequests = "least_requests"
+	StrategyLeastLatency  = "least_latency"
+	StrategySticky        = "sticky"
+)
+
+// Strategy chooses one of candidates. Candidates are never empty and are sorted by index in config
+type Strategy interface {
+	Select(candidates []*Server, key string) *Server
+}
+
+// NewStrategy returns strategy by name. Empty name means failover
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", StrategyFailover:
+		return failover{}, nil
+	case StrategyRoundRobin:
+		return &roundRobin{}, nil
+	case StrategyWeighted:
+		return weighted{}, nil
+	case StrategyLeastRequests:
+		return leastRequests{}, nil
+	case StrategyLeastLatency:
+		return leastLatency{}, nil
+	case StrategySticky:
+		return sticky{}, nil
+	}
+	return nil, fmt.Errorf("unsupported server selection strategy %s", name)
+}
+
+// failover always uses the first available server in configured order
+type failover struct{}
+
+func (failover) Select(candidates []*Server, key string) *Server {
+	return candidates[0]
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (rr *roundRobin) Select(candidates []*Server, key string) *Server {
+	n := atomic.AddUint64(&rr.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weighted chooses random server with probability proportional to its weight
+type weighted struct{}
+
+func (weighted) Select(candidates []*Server, key string) *Server {
+	total := 0
+	for _, s := range candidates {
+		total += s.Weight()
+	}
+	r := rand.Intn(total)
+	for _, s := range candidates {
+		r -= s.Weight()
+		if r < 0 {
+			return s
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// leastRequests chooses server with the least number of requests in flight
+type leastRequests struct{}
+
+func (leastRequests) Select(candidates []*Server, key string) *Server {
+	best := candidates[0]
+	for _, s := range candidates[1:] {
+		if s.Inflight() < best.Inflight() {
+			best = s
+		}
+	}
+	return best
+}
+
+// leastLatency chooses server with the lowest observed latency. Servers without observations are tried first
+type leastLatency struct{}
+
+func (leastLatency) Select(candidates []*Server, key string) *Server {
+	best := candidates[0]
+	for _, s := range candidates[1:] {
+		if s.Latency() < best.Latency() {
+			best = s
+		}
+	}
+	return best
+}
+
+// sticky uses rendezvous hashing of key (username), so the same user gets the same server
+// while it is available and only users of unavailable server are moved to other servers
+type sticky struct{}
+
+func (sticky) Select(candidates []*Server, key string) *Server {
+	var best *Server
+	var bestScore uint64
+	for _, s := range candidates {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte(strconv.Itoa(s.Index())))
+		score := h.Sum64()
+		if best == nil || score > bestScore {
+			best = s
+			bestScore = score
+		}
+	}
+	return best
+}
@@ -0,0 +1,72 @@
+package pwhash
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	prefix   = "pbkdf2-sha256"
+	saltSize = 16
+	keySize  = 32
+	// DefaultIterations is used for stored hashes of passwords
+	DefaultIterations = 210000
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+var b64 = base64.RawStdEncoding
+
+// Hash returns salted PBKDF2-SHA256 hash of password in format pbkdf2-sha256$iterations$salt$hash
+func Hash(pass string, iterations int) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(pass), salt, iterations, keySize, sha256.New)
+	return fmt.Sprintf("%s$%d$%s$%s", prefix, iterations, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify reports if password matches hash created by Hash
+func Verify(pass, hash string) (bool, error) {
+	iterations, salt, want, err := parse(hash)
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2.Key([]byte(pass), salt, iterations, len(want), sha256.New)
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+// Check validates format of hash
+func Check(hash string) error {
+	_, _, _, err := parse(hash)
+	return err
+}
+
+func parse(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != prefix {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	salt, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	key, err := b64.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	return iterations, salt, key, nil
+}
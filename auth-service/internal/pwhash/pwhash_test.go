@@ -0,0 +1,67 @@
+package pwhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	hash, err := Hash("pass", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("Hash() = %s, want pbkdf2-sha256$1000$...", hash)
+	}
+	tests := []struct {
+		name string
+		pass string
+		ok   bool
+	}{
+		{"same password", "pass", true},
+		{"other password", "pass2", false},
+		{"password case", "PASS", false},
+		{"empty password", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := Verify(tt.pass, hash)
+			if err != nil || ok != tt.ok {
+				t.Errorf("Verify() = %v, %v, want %v", ok, err, tt.ok)
+			}
+		})
+	}
+	// salt is random, so hashes of the same password differ
+	if other, _ := Hash("pass", 1000); other == hash {
+		t.Error("hashes of the same password are equal")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		err  error
+	}{
+		{"valid", "pbkdf2-sha256$1000$c2FsdA$a2V5", nil},
+		{"empty", "", ErrInvalidHash},
+		{"plain password", "secret", ErrInvalidHash},
+		{"other algorithm", "bcrypt$1000$c2FsdA$a2V5", ErrInvalidHash},
+		{"missing part", "pbkdf2-sha256$1000$c2FsdA", ErrInvalidHash},
+		{"zero iterations", "pbkdf2-sha256$0$c2FsdA$a2V5", ErrInvalidHash},
+		{"invalid iterations", "pbkdf2-sha256$abc$c2FsdA$a2V5", ErrInvalidHash},
+		{"invalid salt", "pbkdf2-sha256$1000$!!$a2V5", ErrInvalidHash},
+		{"empty key", "pbkdf2-sha256$1000$c2FsdA$", ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.hash); !errors.Is(err, tt.err) {
+				t.Errorf("Check() error = %v, want %v", err, tt.err)
+			}
+			if _, err := Verify("pass", tt.hash); !errors.Is(err, tt.err) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package radiusc
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+var errNoAccountingServers = errors.New("No servers available for accounting")
+
+// SendAccounting sends Accounting-Request to accounting servers. Servers are chosen by accounting
+// selection strategy and circuit breakers, as servers of authentication. The next server is tried
+// until one of them answers
+func (rc *RadiusClient) SendAccounting(rec *globals.AccountingRecord) error {
+	st, err := acctStatusType(rec.StatusType)
+	if err != nil {
+		return err
+	}
+	tried := make([]int, 0, 1)
+	for attempt := 0; attempt < rc.config.NumAccountingServers(); attempt++ {
+		idx, srv, done, err := rc.config.GetAvailableAccountingServer(rec.SessionID, tried)
+		if err != nil {
+			rc.l.Error(err)
+			break
+		}
+		tried = append(tried, idx)
+		err = rc.sendAccounting(rec, st, srv)
+		done(err)
+		if err == nil {
+			return nil
+		}
+		rc.l.Errorf("Accounting server %s failed. Error %s", srv.GetName(), err)
+	}
+	return errNoAccountingServers
+}
+
+func (rc *RadiusClient) sendAccounting(rec *globals.AccountingRecord, st rfc2866.AcctStatusType, srv globals.RadiusProvider) error {
+	packet := radius.New(radius.CodeAccountingRequest, []byte(srv.GetSecret()))
+	rc.setNASAttributes(packet, rec.ClientIP)
+	if err := rfc2865.UserName_SetString(packet, rec.User); err != nil {
+		return err
+	}
+	_ = rfc2866.AcctStatusType_Set(packet, st)
+	_ = rfc2866.AcctSessionID_SetString(packet, rec.SessionID)
+	_ = rfc2866.AcctAuthentic_Set(packet, rfc2866.AcctAuthentic_Value_RADIUS)
+	_ = rfc2869.EventTimestamp_Set(packet, time.Now())
+	if rec.FramedIP != "" {
+		ip := net.ParseIP(rec.FramedIP)
+		if ip == nil {
+			return fmt.Errorf("invalid framed ip %s", rec.FramedIP)
+		}
+		_ = rfc2865.FramedIPAddress_Set(packet, ip)
+	}
+	if st != rfc2866.AcctStatusType_Value_Start {
+		_ = rfc2866.AcctInputOctets_Set(packet, rfc2866.AcctInputOctets(rec.BytesIn&0xffffffff))
+		_ = rfc2869.AcctInputGigawords_Set(packet, rfc2869.AcctInputGigawords(rec.BytesIn>>32))
+		_ = rfc2866.AcctOutputOctets_Set(packet, rfc2866.AcctOutputOctets(rec.BytesOut&0xffffffff))
+		_ = rfc2869.AcctOutputGigawords_Set(packet, rfc2869.AcctOutputGigawords(rec.BytesOut>>32))
+		_ = rfc2866.AcctInputPackets_Set(packet, rfc2866.AcctInputPackets(rec.PacketsIn))
+		_ = rfc2866.AcctOutputPackets_Set(packet, rfc2866.AcctOutputPackets(rec.PacketsOut))
+		_ = rfc2866.AcctSessionTime_Set(packet, rfc2866.AcctSessionTime(rec.SessionTime))
+	}
+	if st == rfc2866.AcctStatusType_Value_Stop && rec.TerminateCause != "" {
+		tc, err := acctTerminateCause(rec.TerminateCause)
+		if err != nil {
+			return err
+		}
+		_ = rfc2866.AcctTerminateCause_Set(packet, tc)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(srv.GetResponseTimeoutSec())*time.Second)
+	defer cancel()
+	response, err := radius.Exchange(ctx, packet, srv.GetAddress()+":"+strconv.Itoa(srv.GetPort()))
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metrics.UpstreamTimeout(srv.GetName())
+			return fmt.Errorf("%w: %s", globals.ErrServerTimeout, err)
+		}
+		return fmt.Errorf("%w: %s", globals.ErrServerUnreachable, err)
+	}
+	if response.Code != radius.CodeAccountingResponse {
+		return fmt.Errorf("unexpected response %d: %s", response.Code, response.Code.String())
+	}
+	return nil
+}
+
+func acctStatusType(s string) (rfc2866.AcctStatusType, error) {
+	switch s {
+	case globals.AcctStatusStart:
+		return rfc2866.AcctStatusType_Value_Start, nil
+	case globals.AcctStatusInterim:
+		return rfc2866.AcctStatusType_Value_InterimUpdate, nil
+	case globals.AcctStatusStop:
+		return rfc2866.AcctStatusType_Value_Stop, nil
+	}
+	return 0, fmt.Errorf("unsupported accounting status type %s", s)
+}
+
+func acctTerminateCause(s string) (rfc2866.AcctTerminateCause, error) {
+	switch s {
+	case globals.AcctTerminateUserRequest:
+		return rfc2866.AcctTerminateCause_Value_UserRequest, nil
+	case globals.AcctTerminateLostCarrier:
+		return rfc2866.AcctTerminateCause_Value_LostCarrier, nil
+	case globals.AcctTerminateIdleTimeout:
+		return rfc2866.AcctTerminateCause_Value_IdleTimeout, nil
+	case globals.AcctTerminateSessionTimeout:
+		return rfc2866.AcctTerminateCause_Value_SessionTimeout, nil
+	case globals.AcctTerminateAdminReset:
+		return rfc2866.AcctTerminateCause_Value_AdminReset, nil
+	case globals.AcctTerminateNASRequest:
+		return rfc2866.AcctTerminateCause_Value_NASRequest, nil
+	case globals.AcctTerminateNASReboot:
+		return rfc2866.AcctTerminateCause_Value_NASReboot, nil
+	}
+	return 0, fmt.Errorf("unsupported terminate cause %s", s)
+}
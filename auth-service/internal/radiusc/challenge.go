@@ -0,0 +1,63 @@
+package radiusc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// challenge keeps everything needed to send the user response
+// to the same radius server which issued Access-Challenge. The server is kept by name
+// and address and is looked up in current config, so reload of its secret is applied
+type challenge struct {
+	server   string
+	addr     string
+	user     string
+	clientIP string
+	state    []byte
+	expires  time.Time
+}
+
+type challengeStore struct {
+	m     sync.Mutex
+	items map[string]*challenge
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{items: make(map[string]*challenge)}
+}
+
+// put saves challenge and returns opaque token for the caller
+func (cs *challengeStore) put(ch *challenge) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	for k, v := range cs.items {
+		if now.After(v.expires) {
+			delete(cs.items, k)
+		}
+	}
+	cs.items[token] = ch
+	return token, nil
+}
+
+// take returns challenge by token. Every token can be used only once
+func (cs *challengeStore) take(token string) (*challenge, bool) {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	ch, ok := cs.items[token]
+	if !ok {
+		return nil, false
+	}
+	delete(cs.items, token)
+	if time.Now().After(ch.expires) {
+		return nil, false
+	}
+	return ch, true
+}
@@ -0,0 +1,114 @@
+package radiusc
+
+import (
+	"auth-service/internal/globals"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChallengeStore(t *testing.T) {
+	tests := []struct {
+		name string
+		// time left until expiry of challenge
+		ttl time.Duration
+		// token is replaced with unknown one
+		unknown bool
+		found   bool
+	}{
+		{"valid", time.Minute, false, true},
+		{"expired", -time.Second, false, false},
+		{"unknown token", time.Minute, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newChallengeStore()
+			ch := &challenge{server: "r1", user: "user", expires: time.Now().Add(tt.ttl)}
+			token, err := cs.put(ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.unknown {
+				token = "0123456789abcdef0123456789abcdef"
+			}
+			got, ok := cs.take(token)
+			if ok != tt.found || (ok && got != ch) {
+				t.Fatalf("take() = %v, %v, want found %v", got, ok, tt.found)
+			}
+			if _, ok := cs.take(token); ok {
+				t.Error("challenge is taken twice")
+			}
+		})
+	}
+}
+
+func TestChallengeStorePurge(t *testing.T) {
+	cs := newChallengeStore()
+	first, err := cs.put(&challenge{expires: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cs.put(&challenge{expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("tokens of challenges are equal")
+	}
+	// expired challenges are removed when new one is saved
+	if _, ok := cs.items[first]; ok || len(cs.items) != 1 {
+		t.Errorf("%d challenges are kept, want only unexpired one", len(cs.items))
+	}
+}
+
+func TestContinueAuthenticationOnce(t *testing.T) {
+	port := startServer(t, "secret")
+	type answer struct {
+		user     string
+		pass     string
+		accepted bool
+		err      error
+	}
+	tests := []struct {
+		name    string
+		answers []answer
+	}{
+		{"replay of accepted answer", []answer{
+			{"user", "123456", true, nil},
+			{"user", "123456", false, globals.ErrChallengeExpired},
+		}},
+		{"answer of other user uses state", []answer{
+			{"other", "123456", false, globals.ErrAuthenticationFailed},
+			{"user", "123456", false, globals.ErrChallengeExpired},
+		}},
+		{"wrong code issues new challenge", []answer{
+			{"user", "000000", false, &globals.AuthChallenge{}},
+			{"user", "123456", false, globals.ErrChallengeExpired},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &testConfig{servers: []*testServer{{"r1", "127.0.0.1", port, "secret"}}}
+			rc := NewClient(cfg)
+			_, err := rc.AuthenticateUser("user", "pass", "10.0.0.1")
+			var ch *globals.AuthChallenge
+			if !errors.As(err, &ch) {
+				t.Fatalf("AuthenticateUser() error = %v, want challenge", err)
+			}
+			for i, a := range tt.answers {
+				res, err := rc.ContinueAuthentication(ch.State, a.user, a.pass, "10.0.0.1")
+				var next *globals.AuthChallenge
+				if _, challenged := a.err.(*globals.AuthChallenge); challenged {
+					if !errors.As(err, &next) || next.State == ch.State {
+						t.Errorf("answer %d: error = %v, want new challenge", i, err)
+					}
+				} else if !errors.Is(err, a.err) {
+					t.Errorf("answer %d: error = %v, want %v", i, err, a.err)
+				}
+				if res.Accepted != a.accepted {
+					t.Errorf("answer %d: accepted = %v, want %v", i, res.Accepted, a.accepted)
+				}
+			}
+		})
+	}
+}
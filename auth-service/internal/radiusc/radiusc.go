@@ -2,9 +2,14 @@ package radiusc
 
 import (
 	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"layeh.com/radius"
@@ -14,15 +19,18 @@ import (
 	"layeh.com/radius/vendors/microsoft"
 )
 
+var errAccessChallenge = errors.New("Access challenge")
+
 type RadiusClient struct {
-	config globals.IRadiusServersProvider
-	l      globals.AppLogger
+	config     globals.IRadiusServersProvider
+	l          globals.AppLogger
+	challenges *challengeStore
 }
 
 // var defaultClient *RadiusClient
 
 func NewClient(c globals.IRadiusServersProvider) *RadiusClient {
-	return &RadiusClient{config: c, l: c.AppLogger()}
+	return &RadiusClient{config: c, l: c.AppLogger(), challenges: newChallengeStore()}
 }
 
 // func Client() *RadiusClient {
@@ -30,22 +38,17 @@ func NewClient(c globals.IRadiusServersProvider) *RadiusClient {
 // }
 
 func (rc *RadiusClient) Authenticate(u, p, clientIP string, srv globals.RadiusProvider) (*radius.Packet, error) {
+	return rc.authenticate(context.Background(), u, p, clientIP, srv, nil)
+}
+
+// authenticate sends Access-Request. State must be set when the request is the response to Access-Challenge.
+// If server answers with Access-Challenge, the response packet is returned with errAccessChallenge
+// Transport errors are wrapped with globals.ErrServerUnreachable
+func (rc *RadiusClient) authenticate(ctx context.Context, u, p, clientIP string, srv globals.RadiusProvider, state []byte) (*radius.Packet, error) {
 	// rcfg := rc.config.RadiusSrv(0)
 
 	packet := radius.New(radius.CodeAccessRequest, []byte(srv.GetSecret()))
-	if rc.config.NASID() != "" {
-		_ = rfc2865.NASIdentifier_AddString(packet, rc.config.NASID())
-	}
-	if rc.config.NASIpV4Addr() != nil {
-		_ = rfc2865.NASIPAddress_Set(packet, rc.config.NASIpV4Addr())
-	}
-	if rc.config.NASPort() != 0 {
-		_ = rfc2865.NASPort_Set(packet, rfc2865.NASPort(rc.config.NASPort()))
-	} else {
-		_ = rfc2865.NASPort_Set(packet, 443)
-	}
-	_ = rfc2865.NASPortType_Set(packet, rfc2865.NASPortType_Value_Virtual)
-	_ = rfc2865.CallingStationID_Set(packet, []byte(clientIP))
+	rc.setNASAttributes(packet, clientIP)
 
 	err := rfc2865.UserName_SetString(packet, u)
 	if err != nil {
@@ -98,13 +101,25 @@ func (rc *RadiusClient) Authenticate(u, p, clientIP string, srv globals.RadiusPr
 		rfc2865.UserPassword_SetString(packet, p)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(srv.GetResponseTimeoutSec())*time.Second)
+	if state != nil {
+		_ = rfc2865.State_Set(packet, state)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(srv.GetResponseTimeoutSec())*time.Second)
 	defer cancel()
 	response, err := radius.Exchange(ctx, packet, srv.GetAddress()+":"+strconv.Itoa(srv.GetPort()))
 	if err != nil {
 		// rc.l.Debugf("%#v", response)
 		rc.l.Error(err)
-		return nil, err
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metrics.UpstreamTimeout(srv.GetName())
+			return nil, fmt.Errorf("%w: %s", globals.ErrServerTimeout, err)
+		}
+		return nil, fmt.Errorf("%w: %s", globals.ErrServerUnreachable, err)
+	}
+	if response.Code == radius.CodeAccessChallenge {
+		rc.l.Debugf("Access challenge. User: %s, server: %s", u, srv.GetAddress())
+		return response, errAccessChallenge
 	}
 	if response.Code != radius.CodeAccessAccept {
 		rc.l.Errorf("%d: %s. User: %s, server: %s", response.Code, response.Code.String(), u, srv.GetAddress())
@@ -114,17 +129,120 @@ func (rc *RadiusClient) Authenticate(u, p, clientIP string, srv globals.RadiusPr
 	return response, nil
 }
 
-func (rc *RadiusClient) AuthenticateUser(u, p, clientIP string) (bool, *globals.NetworkData, error) {
-	authResult := false
-	srv, err := rc.config.GetAvailableRadiusAuthServer()
+func (rc *RadiusClient) setNASAttributes(packet *radius.Packet, clientIP string) {
+	if rc.config.NASID() != "" {
+		_ = rfc2865.NASIdentifier_AddString(packet, rc.config.NASID())
+	}
+	if rc.config.NASIpV4Addr() != nil {
+		_ = rfc2865.NASIPAddress_Set(packet, rc.config.NASIpV4Addr())
+	}
+	if rc.config.NASPort() != 0 {
+		_ = rfc2865.NASPort_Set(packet, rfc2865.NASPort(rc.config.NASPort()))
+	} else {
+		_ = rfc2865.NASPort_Set(packet, 443)
+	}
+	_ = rfc2865.NASPortType_Set(packet, rfc2865.NASPortType_Value_Virtual)
+	_ = rfc2865.CallingStationID_Set(packet, []byte(clientIP))
+}
+
+func (rc *RadiusClient) AuthenticateUser(u, p, clientIP string) (*globals.AuthResult, error) {
+	res := &globals.AuthResult{}
+	ctx, cancel := globals.FailoverContext(rc.config.AuthFailoverDeadline())
+	defer cancel()
+	tried := make([]int, 0, 1)
+	var err error
+	for attempt := 0; attempt < rc.config.AuthFailoverAttempts(); attempt++ {
+		idx, srv, done, err2 := rc.config.GetAvailableRadiusAuthServer(u, tried)
+		if err2 != nil {
+			if err == nil {
+				err = err2
+			}
+			break
+		}
+		tried = append(tried, idx)
+		var pkt *radius.Packet
+		pkt, err = rc.authenticate(ctx, u, p, clientIP, srv, nil)
+		if err == errAccessChallenge {
+			err = rc.newChallenge(pkt, u, clientIP, srv)
+		}
+		done(err)
+		if errors.Is(err, globals.ErrServerUnreachable) && ctx.Err() == nil {
+			rc.l.Errorf("Server %s is unreachable. Trying next server for user %s", srv.GetName(), u)
+			continue
+		}
+		if !errors.Is(err, globals.ErrServerUnreachable) {
+			res.Server = srv.GetName()
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Accepted = true
+		res.NetData = rc.networkData(pkt)
+		return res, nil
+	}
+	return res, err
+}
+
+// ContinueAuthentication sends user response to the server which issued the challenge
+func (rc *RadiusClient) ContinueAuthentication(state, u, p, clientIP string) (*globals.AuthResult, error) {
+	res := &globals.AuthResult{}
+	ch, ok := rc.challenges.take(state)
+	if !ok {
+		rc.l.Errorf("Challenge state is unknown or expired. User: %s", u)
+		return res, globals.ErrChallengeExpired
+	}
+	if ch.user != u || ch.clientIP != clientIP {
+		rc.l.Errorf("Challenge state was issued for another user or client. User: %s, client ip: %s", u, clientIP)
+		return res, globals.ErrAuthenticationFailed
+	}
+	srv, done, ok := rc.config.ChallengeServer(ch.server, ch.addr)
+	if !ok {
+		rc.l.Errorf("Server %s which issued challenge is removed by reload. User: %s", ch.server, u)
+		return res, globals.ErrChallengeExpired
+	}
+	pkt, err := rc.authenticate(context.Background(), u, p, clientIP, srv, ch.state)
+	if err == errAccessChallenge {
+		err = rc.newChallenge(pkt, u, clientIP, srv)
+	}
+	done(err)
+	if !errors.Is(err, globals.ErrServerUnreachable) {
+		res.Server = srv.GetName()
+	}
 	if err != nil {
-		return authResult, nil, err
+		return res, err
 	}
-	pkt, err := rc.Authenticate(u, p, clientIP, srv)
+	res.Accepted = true
+	res.NetData = rc.networkData(pkt)
+	return res, nil
+}
+
+// newChallenge saves State attribute of Access-Challenge and returns challenge for the caller
+func (rc *RadiusClient) newChallenge(pkt *radius.Packet, u, clientIP string, srv globals.RadiusProvider) error {
+	state := rfc2865.State_Get(pkt)
+	if state == nil {
+		rc.l.Errorf("Access challenge without State attribute. User: %s, server: %s", u, srv.GetAddress())
+		return globals.ErrAuthenticationFailed
+	}
+	msgs, _ := rfc2865.ReplyMessage_GetStrings(pkt)
+	token, err := rc.challenges.put(&challenge{
+		server:   srv.GetName(),
+		addr:     srv.GetAddress() + ":" + strconv.Itoa(srv.GetPort()),
+		user:     u,
+		clientIP: clientIP,
+		state:    append([]byte(nil), state...),
+		expires:  time.Now().Add(time.Duration(rc.config.ChallengeTimeoutSec()) * time.Second),
+	})
 	if err != nil {
-		return authResult, nil, err
+		rc.l.Error(err)
+		return err
 	}
-	authResult = true
+	return &globals.AuthChallenge{
+		State:   token,
+		Message: strings.Join(msgs, " "),
+	}
+}
+
+func (rc *RadiusClient) networkData(pkt *radius.Packet) *globals.NetworkData {
 	var vs, ns string
 	v := rfc2865.FramedIPAddress_Get(pkt)
 	if v != nil {
@@ -135,20 +253,64 @@ func (rc *RadiusClient) AuthenticateUser(u, p, clientIP string) (bool, *globals.
 		ns = v2.String()
 	}
 	ndata := &globals.NetworkData{
-		IP:      vs,
-		Netmask: ns,
+		IP:             vs,
+		Netmask:        ns,
+		SessionTimeout: uint32(rfc2865.SessionTimeout_Get(pkt)),
+		IdleTimeout:    uint32(rfc2865.IdleTimeout_Get(pkt)),
+	}
+
+	routes, _ := rfc2865.FramedRoute_GetStrings(pkt)
+	for _, r := range routes {
+		route, err := parseFramedRoute(r)
+		if err != nil {
+			rc.l.Errorf("Skip Framed-Route %q. %s", r, err)
+			continue
+		}
+		ndata.Routes = append(ndata.Routes, route)
+	}
+	if dns := microsoft.MSPrimaryDNSServer_Get(pkt); dns != nil {
+		ndata.DNSServers = append(ndata.DNSServers, dns.String())
 	}
-	return authResult, ndata, nil
+	if dns := microsoft.MSSecondaryDNSServer_Get(pkt); dns != nil {
+		ndata.DNSServers = append(ndata.DNSServers, dns.String())
+	}
+	classes, _ := rfc2865.Class_GetStrings(pkt)
+	ndata.Class = classes
+	msgs, _ := rfc2865.ReplyMessage_GetStrings(pkt)
+	ndata.ReplyMessage = strings.Join(msgs, " ")
+	return ndata
 }
 
-func (rc *RadiusClient) CheckAuthenticateUser(u, p string, serverIdx int) (bool, error) {
+// parseFramedRoute converts Framed-Route value "network[/bits] gateway metric" to "network netmask".
+// Network without prefix length is considered a host route
+func parseFramedRoute(r string) (string, error) {
+	fields := strings.Fields(r)
+	if len(fields) == 0 {
+		return "", errors.New("empty route")
+	}
+	dst := fields[0]
+	if !strings.Contains(dst, "/") {
+		dst += "/32"
+	}
+	_, ipnet, err := net.ParseCIDR(dst)
+	if err != nil {
+		return "", err
+	}
+	if ipnet.IP.To4() == nil {
+		return "", errors.New("only ipv4 routes are supported")
+	}
+	return ipnet.IP.String() + " " + net.IP(ipnet.Mask).String(), nil
+}
+
+func (rc *RadiusClient) CheckAuthenticateUser(ctx context.Context, u, p string, serverIdx int) (bool, error) {
 	authResult := false
 	srv, err := rc.config.RadiusServer(serverIdx)
 	if err != nil {
 		return authResult, err
 	}
-	_, err = rc.Authenticate(u, p, "", srv)
-	if err != nil {
+	_, err = rc.authenticate(ctx, u, p, "", srv, nil)
+	// challenge means the server is alive and has accepted the password of monitoring user
+	if err != nil && err != errAccessChallenge {
 		return authResult, err
 	}
 	authResult = true
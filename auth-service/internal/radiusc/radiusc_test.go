@@ -0,0 +1,191 @@
+package radiusc
+
+import (
+	"auth-service/internal/globals"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+type testServer struct {
+	name   string
+	addr   string
+	port   int
+	secret string
+}
+
+func (s *testServer) GetAddress() string         { return s.addr }
+func (s *testServer) GetPort() int               { return s.port }
+func (s *testServer) GetSecret() string          { return s.secret }
+func (s *testServer) GetProto() string           { return "pap" }
+func (s *testServer) GetResponseTimeoutSec() int { return 2 }
+func (s *testServer) GetName() string            { return s.name }
+
+// testConfig holds radius servers of current config
+type testConfig struct {
+	servers    []*testServer
+	accounting []*testServer
+	// number of finished requests to servers
+	done int
+}
+
+func (c *testConfig) AppLogger() globals.AppLogger { return &globals.DummyLogger{} }
+func (c *testConfig) RadiusServer(i int) (globals.RadiusProvider, error) {
+	return c.servers[i], nil
+}
+func (c *testConfig) NumAuthServers() int { return len(c.servers) }
+func (c *testConfig) GetAvailableRadiusAuthServer(user string, exclude []int) (int, globals.RadiusProvider, func(error), error) {
+	for i, s := range c.servers {
+		excluded := false
+		for _, j := range exclude {
+			excluded = excluded || i == j
+		}
+		if !excluded {
+			return i, s, func(error) { c.done++ }, nil
+		}
+	}
+	return 0, nil, nil, globals.ErrNoServersAvailable
+}
+func (c *testConfig) ChallengeServer(name, addr string) (globals.RadiusProvider, func(error), bool) {
+	for _, s := range c.servers {
+		if s.name == name && s.addr+":"+strconv.Itoa(s.port) == addr {
+			return s, func(error) { c.done++ }, true
+		}
+	}
+	return nil, nil, false
+}
+func (c *testConfig) AuthFailoverAttempts() int           { return len(c.servers) }
+func (c *testConfig) AuthFailoverDeadline() time.Duration { return 0 }
+func (c *testConfig) NASID() string                       { return "test" }
+func (c *testConfig) NASIpV4Addr() net.IP                 { return nil }
+func (c *testConfig) NASPort() uint32                     { return 0 }
+func (c *testConfig) ChallengeTimeoutSec() int            { return 60 }
+func (c *testConfig) NumAccountingServers() int           { return len(c.accounting) }
+func (c *testConfig) GetAvailableAccountingServer(session string, exclude []int) (int, globals.RadiusProvider, func(error), error) {
+	if len(exclude) > 0 || len(c.accounting) == 0 {
+		return 0, nil, nil, globals.ErrNoServersAvailable
+	}
+	return 0, c.accounting[0], func(error) {}, nil
+}
+
+// startServer starts radius server with secret. It accepts password "123456" sent with State
+// "state" and answers other requests with Access-Challenge
+func startServer(t *testing.T, secret string) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			if string(rfc2865.State_Get(r.Packet)) == "state" && rfc2865.UserPassword_GetString(r.Packet) == "123456" {
+				_ = w.Write(r.Response(radius.CodeAccessAccept))
+				return
+			}
+			resp := r.Response(radius.CodeAccessChallenge)
+			_ = rfc2865.State_Set(resp, []byte("state"))
+			_ = w.Write(resp)
+		}),
+	}
+	go func() { _ = srv.Serve(conn) }()
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestContinueAuthenticationAfterReload(t *testing.T) {
+	port := startServer(t, "rotated")
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	tests := []struct {
+		name string
+		// servers of config at the time of response to challenge
+		servers  []*testServer
+		accepted bool
+		err      error
+	}{
+		{"secret is rotated", []*testServer{{"r1", "127.0.0.1", port, "rotated"}}, true, nil},
+		{"server is removed", nil, false, globals.ErrChallengeExpired},
+		{"server is renamed", []*testServer{{"r2", "127.0.0.1", port, "rotated"}}, false, globals.ErrChallengeExpired},
+		{"server is moved", []*testServer{{"r1", "127.0.0.1", port + 1, "rotated"}}, false, globals.ErrChallengeExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &testConfig{}
+			rc := NewClient(cfg)
+			token, err := rc.challenges.put(&challenge{
+				server:   "r1",
+				addr:     addr,
+				user:     "user",
+				clientIP: "10.0.0.1",
+				state:    []byte("state"),
+				expires:  time.Now().Add(time.Minute),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// config is reloaded while user answers challenge
+			cfg.servers = tt.servers
+			res, err := rc.ContinueAuthentication(token, "user", "123456", "10.0.0.1")
+			if !errors.Is(err, tt.err) || res.Accepted != tt.accepted {
+				t.Fatalf("ContinueAuthentication() = %v, %v, want %v, %v", res.Accepted, err, tt.accepted, tt.err)
+			}
+			if tt.accepted && (res.Server != "r1" || cfg.done != 1) {
+				t.Errorf("server = %s, %d finished requests, want r1, 1", res.Server, cfg.done)
+			}
+		})
+	}
+}
+
+func TestAccountingTerminateCause(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	received := make(chan *radius.Packet, 1)
+	srv := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte("secret")),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			received <- r.Packet
+			_ = w.Write(r.Response(radius.CodeAccountingResponse))
+		}),
+	}
+	go func() { _ = srv.Serve(conn) }()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	rc := NewClient(&testConfig{accounting: []*testServer{{"a1", "127.0.0.1", port, "secret"}}})
+	tests := []struct {
+		name  string
+		cause string
+		want  rfc2866.AcctTerminateCause
+	}{
+		{"idle timeout", globals.AcctTerminateIdleTimeout, rfc2866.AcctTerminateCause_Value_IdleTimeout},
+		{"admin reset", globals.AcctTerminateAdminReset, rfc2866.AcctTerminateCause_Value_AdminReset},
+		{"user request", globals.AcctTerminateUserRequest, rfc2866.AcctTerminateCause_Value_UserRequest},
+		{"not set", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &globals.AccountingRecord{StatusType: globals.AcctStatusStop, SessionID: "s1", User: "user", TerminateCause: tt.cause}
+			if err := rc.SendAccounting(rec); err != nil {
+				t.Fatal(err)
+			}
+			pkt := <-received
+			_, err := rfc2866.AcctTerminateCause_Lookup(pkt)
+			if tt.cause == "" {
+				if err == nil {
+					t.Error("Acct-Terminate-Cause is sent without terminate cause")
+				}
+				return
+			}
+			if got := rfc2866.AcctTerminateCause_Get(pkt); got != tt.want {
+				t.Errorf("Acct-Terminate-Cause = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package radiusc
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+// ProbeServer sends Status-Server (RFC 5997) to server. Any valid response means the server is alive
+func (rc *RadiusClient) ProbeServer(ctx context.Context, serverIdx int) error {
+	srv, err := rc.config.RadiusServer(serverIdx)
+	if err != nil {
+		return err
+	}
+	packet := radius.New(radius.CodeStatusServer, []byte(srv.GetSecret()))
+	if rc.config.NASID() != "" {
+		_ = rfc2865.NASIdentifier_AddString(packet, rc.config.NASID())
+	}
+	if err := setMessageAuthenticator(packet); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(srv.GetResponseTimeoutSec())*time.Second)
+	defer cancel()
+	response, err := radius.Exchange(ctx, packet, srv.GetAddress()+":"+strconv.Itoa(srv.GetPort()))
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metrics.UpstreamTimeout(srv.GetName())
+			return fmt.Errorf("%w: %s", globals.ErrServerTimeout, err)
+		}
+		return fmt.Errorf("%w: %s", globals.ErrServerUnreachable, err)
+	}
+	rc.l.Debugf("Status-Server response %s from server %s", response.Code.String(), srv.GetName())
+	return nil
+}
+
+// setMessageAuthenticator adds Message-Authenticator (RFC 3579) required in Status-Server packets.
+// It must be the last change of packet attributes
+func setMessageAuthenticator(packet *radius.Packet) error {
+	if err := rfc2869.MessageAuthenticator_Set(packet, make([]byte, md5.Size)); err != nil {
+		return err
+	}
+	wire, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(md5.New, packet.Secret)
+	_, _ = mac.Write(wire)
+	return rfc2869.MessageAuthenticator_Set(packet, mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	prefixFile  = "file:"
+	prefixEnv   = "env:"
+	prefixExec  = "exec:"
+	prefixPlain = "plain:"
+	redacted    = "******"
+)
+
+// time limit of command which returns secret
+const execTimeout = 10 * time.Second
+
+// Secret is a value from config which must not be printed or logged.
+// Use Value to get the value
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Resolve returns value of reference:
+//
+//	file:/path - content of file without trailing newline
+//	env:NAME - value of environment variable
+//	exec:/path/to/command args - output of command without trailing newline
+//	plain:value - value as is, for literal values starting with one of prefixes
+//
+// Other values are returned as is
+func Resolve(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, prefixFile):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(ref, prefixFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(ref, prefixEnv):
+		name := strings.TrimPrefix(ref, prefixEnv)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(ref, prefixExec):
+		return run(strings.Fields(strings.TrimPrefix(ref, prefixExec)))
+	case strings.HasPrefix(ref, prefixPlain):
+		return strings.TrimPrefix(ref, prefixPlain), nil
+	}
+	return ref, nil
+}
+
+func run(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("command is empty")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("command %s failed. Error %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// DecodeHook resolves references of config values decoded to Secret
+func DecodeHook() mapstructure.DecodeHookFuncType {
+	return func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		if to != secretType || from.Kind() != reflect.String {
+			return data, nil
+		}
+		v, err := Resolve(reflect.ValueOf(data).String())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve secret: %w", err)
+		}
+		return Secret(v), nil
+	}
+}
@@ -0,0 +1,118 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SECRET_TEST_VALUE", "from-env")
+	t.Cleanup(func() { os.Unsetenv("SECRET_TEST_VALUE") })
+	tests := []struct {
+		name string
+		ref  string
+		want string
+		err  bool
+	}{
+		{"literal", "secret", "secret", false},
+		{"empty", "", "", false},
+		{"file without trailing newline", "file:" + file, "from-file", false},
+		{"missing file", "file:" + file + ".missing", "", true},
+		{"env", "env:SECRET_TEST_VALUE", "from-env", false},
+		{"unset env", "env:SECRET_TEST_UNSET", "", true},
+		{"exec with args", "exec:echo from exec", "from exec", false},
+		{"failed command", "exec:false", "", true},
+		{"empty command", "exec:", "", true},
+		{"plain reference", "plain:file:abc", "file:abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.ref)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("Resolve() = %q, %v, want %q, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	s := struct {
+		Name   string
+		Secret Secret `json:"secret"`
+	}{"server1", "radius-secret"}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{
+		s.Secret.String(),
+		fmt.Sprintf("%v", s),
+		fmt.Sprintf("%+v", s),
+		fmt.Sprintf("%#v", s),
+		fmt.Sprintf("%s", s.Secret),
+		string(b),
+	} {
+		if strings.Contains(out, "radius-secret") || !strings.Contains(out, redacted) {
+			t.Errorf("secret is not redacted in %s", out)
+		}
+	}
+	if s.Secret.Value() != "radius-secret" {
+		t.Errorf("Value() = %q", s.Secret.Value())
+	}
+	if Secret("").String() != "" {
+		t.Error("empty secret is shown as redacted")
+	}
+}
+
+func TestDecodeHook(t *testing.T) {
+	os.Setenv("SECRET_TEST_VALUE", "from-env")
+	t.Cleanup(func() { os.Unsetenv("SECRET_TEST_VALUE") })
+	tests := []struct {
+		name   string
+		input  map[string]interface{}
+		secret string
+		// plain string fields are never resolved
+		plain string
+		err   bool
+	}{
+		{"reference", map[string]interface{}{"secret": "env:SECRET_TEST_VALUE", "plain": "env:SECRET_TEST_VALUE"}, "from-env", "env:SECRET_TEST_VALUE", false},
+		{"literal", map[string]interface{}{"secret": "abc"}, "abc", "", false},
+		{"unresolved reference", map[string]interface{}{"secret": "env:SECRET_TEST_UNSET"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out struct {
+				Secret Secret
+				Plain  string
+			}
+			dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{DecodeHook: DecodeHook(), Result: &out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = dec.Decode(tt.input)
+			if (err != nil) != tt.err {
+				t.Fatalf("Decode() error = %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				if strings.Contains(err.Error(), "from-env") {
+					t.Errorf("error %s contains secret", err)
+				}
+				return
+			}
+			if out.Secret.Value() != tt.secret || out.Plain != tt.plain {
+				t.Errorf("Decode() = %q, %q, want %q, %q", out.Secret.Value(), out.Plain, tt.secret, tt.plain)
+			}
+		})
+	}
+}
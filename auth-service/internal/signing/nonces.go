@@ -0,0 +1,56 @@
+package signing
+
+import (
+	"sync"
+	"time"
+)
+
+// how often expired nonces are removed
+const sweepInterval = time.Minute
+
+// DefaultMaxNonces limits memory used by nonces
+const DefaultMaxNonces = 1 << 20
+
+// Nonces remembers used nonces until timestamps of their requests become stale.
+// It lives across config reloads
+type Nonces struct {
+	m         sync.Mutex
+	max       int
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewNonces creates store of at most max nonces. Requests are rejected when store is full
+func NewNonces(max int) *Nonces {
+	if max <= 0 {
+		max = DefaultMaxNonces
+	}
+	return &Nonces{max: max, seen: make(map[string]time.Time)}
+}
+
+// use records nonce of key until expires
+func (n *Nonces) use(key, nonce string, expires, now time.Time) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if since := now.Sub(n.lastSweep); since >= sweepInterval || (len(n.seen) >= n.max && since >= time.Second) {
+		n.sweep(now)
+	}
+	id := key + "\x00" + nonce
+	if exp, ok := n.seen[id]; ok && now.Before(exp) {
+		return ErrReplay
+	}
+	if len(n.seen) >= n.max {
+		return ErrTooManyNonces
+	}
+	n.seen[id] = expires
+	return nil
+}
+
+func (n *Nonces) sweep(now time.Time) {
+	for id, exp := range n.seen {
+		if !now.Before(exp) {
+			delete(n.seen, id)
+		}
+	}
+	n.lastSweep = now
+}
@@ -0,0 +1,140 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers of signed request
+const (
+	HeaderKey       = "X-Signature-Key"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// DefaultMaxSkew is allowed difference between timestamp of request and time of service
+const DefaultMaxSkew = 5 * time.Minute
+
+// limits of nonce length
+const (
+	MinNonceLen = 16
+	MaxNonceLen = 128
+)
+
+var (
+	ErrMissing    = errors.New("request is not signed")
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrTimestamp  = errors.New("timestamp is invalid or stale")
+	ErrNonce      = errors.New("nonce is invalid")
+	ErrReplay     = errors.New("nonce is already used")
+	ErrSignature  = errors.New("signature is invalid")
+	// too many requests were signed within allowed skew
+	ErrTooManyNonces = errors.New("too many nonces")
+)
+
+// Key is shared secret of one caller
+type Key struct {
+	Name   string
+	Secret string
+}
+
+// Verifier checks signatures of requests. Nil verifier means signing is disabled
+type Verifier struct {
+	keys    map[string][]byte
+	maxSkew time.Duration
+	// requests without signature headers are accepted
+	allowUnsigned bool
+}
+
+func New(keys []Key, maxSkew time.Duration, allowUnsigned bool) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	v := &Verifier{keys: make(map[string][]byte, len(keys)), maxSkew: maxSkew, allowUnsigned: allowUnsigned}
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, errors.New("name of signing key is empty")
+		}
+		if k.Secret == "" {
+			return nil, fmt.Errorf("secret of signing key %s is empty", k.Name)
+		}
+		if _, ok := v.keys[k.Name]; ok {
+			return nil, fmt.Errorf("duplicate signing key %s", k.Name)
+		}
+		v.keys[k.Name] = []byte(k.Secret)
+	}
+	return v, nil
+}
+
+// StringToSign returns signed content of request:
+//
+//	METHOD\nREQUEST-URI\nTIMESTAMP\nNONCE\nhex(SHA-256(body))
+//
+// REQUEST-URI is path with query as sent in request line
+func StringToSign(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sign returns hex encoded HMAC-SHA256 of request
+func Sign(secret, method, uri, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, uri, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature headers h of request and records nonce in nonces.
+// It returns name of signing key. Unsigned request is accepted with empty name if allowed
+func (v *Verifier) Verify(h http.Header, method, uri string, body []byte, now time.Time, nonces *Nonces) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	name := h.Get(HeaderKey)
+	sig := h.Get(HeaderSignature)
+	if name == "" && sig == "" {
+		if v.allowUnsigned {
+			return "", nil
+		}
+		return "", ErrMissing
+	}
+	secret, ok := v.keys[name]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	ts := h.Get(HeaderTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return name, ErrTimestamp
+	}
+	t := time.Unix(sec, 0)
+	if now.Sub(t) > v.maxSkew || t.Sub(now) > v.maxSkew {
+		return name, ErrTimestamp
+	}
+	nonce := h.Get(HeaderNonce)
+	if len(nonce) < MinNonceLen || len(nonce) > MaxNonceLen {
+		return name, ErrNonce
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return name, ErrSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(StringToSign(method, uri, ts, nonce, body)))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return name, ErrSignature
+	}
+	// nonce is remembered only for valid signatures, so it can't be burned by others.
+	// After t+maxSkew the timestamp is stale and the nonce is not needed any more
+	return name, nonces.use(name, nonce, t.Add(v.maxSkew), now)
+}
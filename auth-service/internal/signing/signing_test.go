@@ -0,0 +1,259 @@
+package signing
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret = "0123456789abcdef0123456789abcdef"
+	testNonce  = "0123456789abcdef"
+	testURI    = "/auth?x=1"
+)
+
+var testBody = []byte(`{"u":"user","p":"pass"}`)
+
+// signed returns headers of request signed with secret at ts
+func signed(name, secret string, ts time.Time, nonce string) http.Header {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	h := http.Header{}
+	h.Set(HeaderKey, name)
+	h.Set(HeaderTimestamp, t)
+	h.Set(HeaderNonce, nonce)
+	h.Set(HeaderSignature, Sign(secret, http.MethodPost, testURI, t, nonce, testBody))
+	return h
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v, err := New([]Key{{Name: "vpn1", Secret: testSecret}}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		header func() http.Header
+		method string
+		uri    string
+		body   []byte
+		err    error
+	}{
+		{
+			name:   "valid",
+			header: func() http.Header { return signed("vpn1", testSecret, now, testNonce) },
+		},
+		{
+			name:   "skew within limit",
+			header: func() http.Header { return signed("vpn1", testSecret, now.Add(-59*time.Second), testNonce) },
+		},
+		{
+			name:   "clock of caller ahead within limit",
+			header: func() http.Header { return signed("vpn1", testSecret, now.Add(59*time.Second), testNonce) },
+		},
+		{
+			name:   "missing",
+			header: func() http.Header { return http.Header{} },
+			err:    ErrMissing,
+		},
+		{
+			name:   "unknown key",
+			header: func() http.Header { return signed("vpn2", testSecret, now, testNonce) },
+			err:    ErrUnknownKey,
+		},
+		{
+			name:   "wrong secret",
+			header: func() http.Header { return signed("vpn1", testSecret+"x", now, testNonce) },
+			err:    ErrSignature,
+		},
+		{
+			name:   "stale timestamp",
+			header: func() http.Header { return signed("vpn1", testSecret, now.Add(-61*time.Second), testNonce) },
+			err:    ErrTimestamp,
+		},
+		{
+			name:   "timestamp in future",
+			header: func() http.Header { return signed("vpn1", testSecret, now.Add(61*time.Second), testNonce) },
+			err:    ErrTimestamp,
+		},
+		{
+			name: "malformed timestamp",
+			header: func() http.Header {
+				h := signed("vpn1", testSecret, now, testNonce)
+				h.Set(HeaderTimestamp, "yesterday")
+				return h
+			},
+			err: ErrTimestamp,
+		},
+		{
+			name:   "short nonce",
+			header: func() http.Header { return signed("vpn1", testSecret, now, "0123") },
+			err:    ErrNonce,
+		},
+		{
+			name: "malformed signature",
+			header: func() http.Header {
+				h := signed("vpn1", testSecret, now, testNonce)
+				h.Set(HeaderSignature, "not hex")
+				return h
+			},
+			err: ErrSignature,
+		},
+		{
+			name:   "other method",
+			header: func() http.Header { return signed("vpn1", testSecret, now, testNonce) },
+			method: http.MethodPut,
+			err:    ErrSignature,
+		},
+		{
+			name:   "other uri",
+			header: func() http.Header { return signed("vpn1", testSecret, now, testNonce) },
+			uri:    "/auth?x=2",
+			err:    ErrSignature,
+		},
+		{
+			name:   "other body",
+			header: func() http.Header { return signed("vpn1", testSecret, now, testNonce) },
+			body:   []byte(`{"u":"admin","p":"pass"}`),
+			err:    ErrSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, uri, body := http.MethodPost, testURI, testBody
+			if tt.method != "" {
+				method = tt.method
+			}
+			if tt.uri != "" {
+				uri = tt.uri
+			}
+			if tt.body != nil {
+				body = tt.body
+			}
+			_, err := v.Verify(tt.header(), method, uri, body, now, NewNonces(0))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowUnsigned bool
+		err           error
+	}{
+		{"rejected", false, ErrMissing},
+		{"allowed", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := New([]Key{{Name: "vpn1", Secret: testSecret}}, 0, tt.allowUnsigned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := v.Verify(http.Header{}, http.MethodPost, testURI, testBody, time.Now(), NewNonces(0)); !errors.Is(err, tt.err) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+	var disabled *Verifier
+	if _, err := disabled.Verify(http.Header{}, http.MethodPost, testURI, testBody, time.Now(), nil); err != nil {
+		t.Errorf("nil verifier: Verify() error = %v", err)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v, err := New([]Key{{Name: "vpn1", Secret: testSecret}, {Name: "vpn2", Secret: testSecret}}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonces := NewNonces(0)
+	steps := []struct {
+		name string
+		key  string
+		ts   time.Time
+		// time of verification
+		at    time.Time
+		nonce string
+		err   error
+	}{
+		{"first use", "vpn1", now, now, testNonce, nil},
+		{"replay", "vpn1", now, now.Add(time.Second), testNonce, ErrReplay},
+		{"same nonce of other key", "vpn2", now, now.Add(time.Second), testNonce, nil},
+		{"new nonce", "vpn1", now, now.Add(time.Second), testNonce + "1", nil},
+		{"replay with new timestamp", "vpn1", now.Add(30 * time.Second), now.Add(30 * time.Second), testNonce, ErrReplay},
+		{"nonce after its timestamp is stale", "vpn1", now.Add(61 * time.Second), now.Add(61 * time.Second), testNonce, nil},
+	}
+	for _, st := range steps {
+		h := signed(st.key, testSecret, st.ts, st.nonce)
+		if _, err := v.Verify(h, http.MethodPost, testURI, testBody, st.at, nonces); !errors.Is(err, st.err) {
+			t.Errorf("%s: Verify() error = %v, want %v", st.name, err, st.err)
+		}
+	}
+}
+
+func TestVerifyInvalidSignatureDoesNotBurnNonce(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v, err := New([]Key{{Name: "vpn1", Secret: testSecret}}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonces := NewNonces(0)
+	if _, err := v.Verify(signed("vpn1", "wrong", now, testNonce), http.MethodPost, testURI, testBody, now, nonces); !errors.Is(err, ErrSignature) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrSignature)
+	}
+	if _, err := v.Verify(signed("vpn1", testSecret, now, testNonce), http.MethodPost, testURI, testBody, now, nonces); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+func TestNoncesEviction(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	n := NewNonces(2)
+	steps := []struct {
+		name    string
+		nonce   string
+		expires time.Time
+		at      time.Time
+		err     error
+	}{
+		{"first", "a", now.Add(time.Minute), now, nil},
+		{"second", "b", now.Add(2 * time.Minute), now, nil},
+		{"store is full", "c", now.Add(time.Minute), now, ErrTooManyNonces},
+		{"replay is reported before full store", "a", now.Add(time.Minute), now, ErrReplay},
+		{"expired nonce is swept", "c", now.Add(3 * time.Minute), now.Add(time.Minute), nil},
+		{"full again", "d", now.Add(3 * time.Minute), now.Add(time.Minute), ErrTooManyNonces},
+		{"expired nonce can be used again", "a", now.Add(4 * time.Minute), now.Add(2 * time.Minute), nil},
+	}
+	for _, st := range steps {
+		if err := n.use("vpn1", st.nonce, st.expires, st.at); !errors.Is(err, st.err) {
+			t.Errorf("%s: use() error = %v, want %v", st.name, err, st.err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+		ok   bool
+	}{
+		{"valid", []Key{{Name: "vpn1", Secret: testSecret}}, true},
+		{"no keys", nil, false},
+		{"empty name", []Key{{Secret: testSecret}}, false},
+		{"empty secret", []Key{{Name: "vpn1"}}, false},
+		{"duplicate", []Key{{Name: "vpn1", Secret: testSecret}, {Name: "vpn1", Secret: testSecret}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.keys, 0, false); (err == nil) != tt.ok {
+				t.Errorf("New() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
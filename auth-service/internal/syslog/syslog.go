@@ -0,0 +1,349 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// messages waiting to be sent. Newer messages are dropped when the queue is full
+	queueSize = 4096
+	// pause after failed attempt to send message grows from minBackoff to maxBackoff
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	ErrQueueFull = errors.New("syslog queue is full, message is dropped")
+	ErrClosed    = errors.New("syslog writer is closed")
+)
+
+// severities of RFC 5424
+const (
+	SevError   = 3
+	SevWarning = 4
+	SevNotice  = 5
+	SevInfo    = 6
+	SevDebug   = 7
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"authpriv": 10,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// Options configures connection to syslog server
+type Options struct {
+	// udp, tcp, tls or unix
+	Network string
+	// host:port or path of unix socket
+	Address string
+	// CA certificates used to verify syslog server in tls mode. System pool is used if empty
+	CAFile   string
+	Facility string
+	AppName  string
+}
+
+// Writer sends RFC 5424 messages to syslog server. Messages are framed with octet counting
+// over tcp and tls (RFC 6587, RFC 5425). Messages are queued and sent by one goroutine, so
+// callers never wait for the server. Connection is reopened after write error with growing pause.
+// Messages are dropped when the queue is full
+type Writer struct {
+	o        Options
+	network  string
+	facility int
+	hostname string
+	tlsCfg   *tls.Config
+	// conn is used only by goroutine of run
+	conn    net.Conn
+	queue   chan string
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+	onDrop  func()
+}
+
+// Check returns error if options are invalid. Connection is not opened
+func Check(o Options) error {
+	_, err := newWriter(o)
+	return err
+}
+
+// New creates writer and starts sending of queued messages. Writer must be closed
+func New(o Options) (*Writer, error) {
+	w, err := newWriter(o)
+	if err != nil {
+		return nil, err
+	}
+	w.queue = make(chan string, queueSize)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run()
+	return w, nil
+}
+
+func newWriter(o Options) (*Writer, error) {
+	if o.Facility == "" {
+		o.Facility = "authpriv"
+	}
+	if o.AppName == "" {
+		o.AppName = "auth-service"
+	}
+	f, ok := facilities[o.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %s", o.Facility)
+	}
+	w := &Writer{o: o, facility: f}
+	switch o.Network {
+	case "udp", "tcp", "unix":
+		w.network = o.Network
+	case "tls":
+		w.network = "tcp"
+		w.tlsCfg = &tls.Config{}
+		if o.CAFile != "" {
+			pem, err := ioutil.ReadFile(o.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+			}
+			w.tlsCfg.RootCAs = pool
+		}
+		if host, _, err := net.SplitHostPort(o.Address); err == nil {
+			w.tlsCfg.ServerName = host
+		}
+	default:
+		return nil, fmt.Errorf("unknown syslog network %s", o.Network)
+	}
+	if o.Address == "" {
+		return nil, errors.New("syslog address is empty")
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+	return w, nil
+}
+
+// Probe opens connection to syslog server and closes it
+func Probe(o Options) error {
+	w, err := newWriter(o)
+	if err != nil {
+		return err
+	}
+	c, err := w.dial()
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// SetDropListener sets function called for every dropped message. It must be set before writing
+func (w *Writer) SetDropListener(f func()) {
+	w.onDrop = f
+}
+
+// Dropped returns number of messages dropped because queue was full
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// WriteMessage queues msg with severity sev and message id msgID.
+// ErrQueueFull is returned if the message is dropped
+func (w *Writer) WriteMessage(sev int, msgID, msg string) error {
+	if msgID == "" {
+		msgID = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		w.facility*8+sev,
+		time.Now().Format(time.RFC3339Nano),
+		w.hostname,
+		w.o.AppName,
+		os.Getpid(),
+		msgID,
+		strings.TrimRight(msg, "\n"),
+	)
+	select {
+	case <-w.stop:
+		return ErrClosed
+	default:
+	}
+	select {
+	case w.queue <- line:
+		return nil
+	default:
+		w.drop(1)
+		return ErrQueueFull
+	}
+}
+
+func (w *Writer) drop(n int) {
+	atomic.AddUint64(&w.dropped, uint64(n))
+	if w.onDrop != nil {
+		for i := 0; i < n; i++ {
+			w.onDrop()
+		}
+	}
+}
+
+// Severity returns writer which sends every Write call as one message with severity sev
+func (w *Writer) Severity(sev int) *SeverityWriter {
+	return &SeverityWriter{w: w, sev: sev}
+}
+
+type SeverityWriter struct {
+	w   *Writer
+	sev int
+}
+
+// Write never fails on full queue: dropped messages are counted by writer
+func (sw *SeverityWriter) Write(p []byte) (int, error) {
+	if err := sw.w.WriteMessage(sw.sev, "", string(p)); err != nil && err != ErrQueueFull {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (sw *SeverityWriter) Sync() error {
+	return nil
+}
+
+// Close stops writer. Queued messages are sent if the server is reachable, otherwise they are dropped
+func (w *Writer) Close() error {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+// run sends queued messages until writer is closed
+func (w *Writer) run() {
+	defer close(w.done)
+	defer w.close()
+	for {
+		select {
+		case line := <-w.queue:
+			if !w.deliver(line) {
+				w.drop(1 + len(w.queue))
+				return
+			}
+		case <-w.stop:
+			w.flush()
+			return
+		}
+	}
+}
+
+// deliver sends line, reconnecting after errors with pause from minBackoff to maxBackoff.
+// It returns false if writer is closed before line is sent
+func (w *Writer) deliver(line string) bool {
+	backoff := minBackoff
+	for {
+		if w.sendOnce(line) == nil {
+			return true
+		}
+		select {
+		case <-w.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// flush sends queued messages without waiting between attempts. Messages left after error are dropped
+func (w *Writer) flush() {
+	for {
+		select {
+		case line := <-w.queue:
+			if w.sendOnce(line) != nil {
+				w.drop(1 + len(w.queue))
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// sendOnce sends line and tries once again with new connection on error
+func (w *Writer) sendOnce(line string) error {
+	err := w.send(line)
+	if err == nil {
+		return nil
+	}
+	// server might close connection. Try once again with new connection
+	w.close()
+	if err = w.send(line); err != nil {
+		w.close()
+	}
+	return err
+}
+
+func (w *Writer) send(line string) error {
+	if w.conn == nil {
+		c, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.conn = c
+	}
+	if w.network == "tcp" {
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := w.conn.Write([]byte(line))
+	return err
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	if w.tlsCfg != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", w.o.Address, w.tlsCfg)
+	}
+	if w.network == "unix" {
+		// local syslog daemons usually listen on datagram socket
+		c, err := net.DialTimeout("unixgram", w.o.Address, dialTimeout)
+		if err == nil {
+			return c, nil
+		}
+		return net.DialTimeout("unix", w.o.Address, dialTimeout)
+	}
+	return net.DialTimeout(w.network, w.o.Address, dialTimeout)
+}
+
+func (w *Writer) close() {
+	if w.conn == nil {
+		return
+	}
+	_ = w.conn.Close()
+	w.conn = nil
+}
@@ -0,0 +1,275 @@
+package throttle
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopes of failure tracking
+const (
+	ScopeUser     = "user"
+	ScopeClientIP = "client_ip"
+	ScopeUserIP   = "user_ip"
+)
+
+const cleanupInterval = time.Minute
+
+// Rule locks key for Lockout after MaxFailures failed attempts within Window.
+// Every next lockout of the same key is twice longer up to MaxLockout. Zero MaxFailures disables the rule
+type Rule struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+}
+
+type Config struct {
+	User     Rule
+	ClientIP Rule
+	UserIP   Rule
+}
+
+// Lockout describes state of one tracked key
+type Lockout struct {
+	Scope       string     `json:"scope"`
+	User        string     `json:"user,omitempty"`
+	ClientIP    string     `json:"client_ip,omitempty"`
+	Failures    int        `json:"failures"`
+	Lockouts    int        `json:"lockouts"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+type entry struct {
+	user        string
+	clientIP    string
+	failures    []time.Time
+	lockouts    int
+	lockedUntil time.Time
+}
+
+type scope struct {
+	name    string
+	rule    Rule
+	entries map[string]*entry
+}
+
+// Throttle tracks failed authentications per user, per client ip and per user and client ip
+type Throttle struct {
+	m      sync.Mutex
+	scopes []*scope
+	// called when key is locked
+	onLockout func(scope string)
+}
+
+func New(cfg Config) *Throttle {
+	t := &Throttle{}
+	for _, s := range []struct {
+		name string
+		rule Rule
+	}{
+		{ScopeUser, cfg.User},
+		{ScopeClientIP, cfg.ClientIP},
+		{ScopeUserIP, cfg.UserIP},
+	} {
+		if s.rule.MaxFailures <= 0 {
+			continue
+		}
+		if s.rule.MaxLockout < s.rule.Lockout {
+			s.rule.MaxLockout = s.rule.Lockout
+		}
+		t.scopes = append(t.scopes, &scope{name: s.name, rule: s.rule, entries: make(map[string]*entry)})
+	}
+	return t
+}
+
+// SetLockoutListener sets function called when any key is locked
+func (t *Throttle) SetLockoutListener(f func(scope string)) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.onLockout = f
+}
+
+// Check returns scope and remaining time of the longest lockout of user or client ip.
+// Empty scope means request is allowed
+func (t *Throttle) Check(user, clientIP string) (string, time.Duration) {
+	now := time.Now()
+	t.m.Lock()
+	defer t.m.Unlock()
+	var locked string
+	var wait time.Duration
+	for _, s := range t.scopes {
+		e, ok := s.entries[key(s.name, user, clientIP)]
+		if !ok {
+			continue
+		}
+		if d := e.lockedUntil.Sub(now); d > wait {
+			locked = s.name
+			wait = d
+		}
+	}
+	return locked, wait
+}
+
+// Failure records rejected authentication
+func (t *Throttle) Failure(user, clientIP string) {
+	now := time.Now()
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, s := range t.scopes {
+		k := key(s.name, user, clientIP)
+		e, ok := s.entries[k]
+		if !ok {
+			e = &entry{user: strings.ToLower(user), clientIP: clientIP}
+			if s.name == ScopeUser {
+				e.clientIP = ""
+			}
+			if s.name == ScopeClientIP {
+				e.user = ""
+			}
+			s.entries[k] = e
+		}
+		e.failures = append(prune(e.failures, now.Add(-s.rule.Window)), now)
+		if len(e.failures) < s.rule.MaxFailures {
+			continue
+		}
+		// lockouts are counted from scratch when the key behaved well long enough
+		if !e.lockedUntil.IsZero() && now.Sub(e.lockedUntil) > s.rule.MaxLockout {
+			e.lockouts = 0
+		}
+		e.lockouts++
+		e.lockedUntil = now.Add(backoff(s.rule, e.lockouts))
+		e.failures = e.failures[:0]
+		if t.onLockout != nil {
+			t.onLockout(s.name)
+		}
+	}
+}
+
+// Success resets failures of user and of user with client ip. Failures of client ip are kept,
+// because password spraying may guess some passwords
+func (t *Throttle) Success(user, clientIP string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, s := range t.scopes {
+		if s.name == ScopeClientIP {
+			continue
+		}
+		k := key(s.name, user, clientIP)
+		if e, ok := s.entries[k]; ok && !e.lockedUntil.After(time.Now()) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// Lockouts returns state of all tracked keys
+func (t *Throttle) Lockouts() []Lockout {
+	now := time.Now()
+	t.m.Lock()
+	defer t.m.Unlock()
+	res := make([]Lockout, 0)
+	for _, s := range t.scopes {
+		for _, e := range s.entries {
+			lo := Lockout{
+				Scope:    s.name,
+				User:     e.user,
+				ClientIP: e.clientIP,
+				Failures: len(prune(e.failures, now.Add(-s.rule.Window))),
+				Lockouts: e.lockouts,
+			}
+			if e.lockedUntil.After(now) {
+				until := e.lockedUntil
+				lo.LockedUntil = &until
+			}
+			res = append(res, lo)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Scope != res[j].Scope {
+			return res[i].Scope < res[j].Scope
+		}
+		return res[i].User+res[i].ClientIP < res[j].User+res[j].ClientIP
+	})
+	return res
+}
+
+// Clear removes state of keys with user and client ip. Empty user or client ip matches any value.
+// Returns number of removed keys
+func (t *Throttle) Clear(user, clientIP string) int {
+	user = strings.ToLower(user)
+	t.m.Lock()
+	defer t.m.Unlock()
+	n := 0
+	for _, s := range t.scopes {
+		for k, e := range s.entries {
+			if user != "" && e.user != user {
+				continue
+			}
+			if clientIP != "" && e.clientIP != clientIP {
+				continue
+			}
+			delete(s.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// Run periodically removes expired keys until ctx is done
+func (t *Throttle) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.cleanup(now)
+		}
+	}
+}
+
+func (t *Throttle) cleanup(now time.Time) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, s := range t.scopes {
+		for k, e := range s.entries {
+			e.failures = prune(e.failures, now.Add(-s.rule.Window))
+			if len(e.failures) == 0 && now.Sub(e.lockedUntil) > s.rule.MaxLockout {
+				delete(s.entries, k)
+			}
+		}
+	}
+}
+
+func key(scope, user, clientIP string) string {
+	switch scope {
+	case ScopeUser:
+		return strings.ToLower(user)
+	case ScopeClientIP:
+		return clientIP
+	}
+	return strings.ToLower(user) + "\x00" + clientIP
+}
+
+// prune removes failures older than from
+func prune(failures []time.Time, from time.Time) []time.Time {
+	i := 0
+	for i < len(failures) && failures[i].Before(from) {
+		i++
+	}
+	return failures[i:]
+}
+
+func backoff(r Rule, lockouts int) time.Duration {
+	d := r.Lockout
+	for i := 1; i < lockouts && d < r.MaxLockout; i++ {
+		d *= 2
+	}
+	if d > r.MaxLockout {
+		d = r.MaxLockout
+	}
+	return d
+}
@@ -0,0 +1,212 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+// elapse moves all recorded times of th back by d, as if d has passed
+func elapse(th *Throttle, d time.Duration) {
+	th.m.Lock()
+	defer th.m.Unlock()
+	for _, s := range th.scopes {
+		for _, e := range s.entries {
+			for i := range e.failures {
+				e.failures[i] = e.failures[i].Add(-d)
+			}
+			if !e.lockedUntil.IsZero() {
+				e.lockedUntil = e.lockedUntil.Add(-d)
+			}
+		}
+	}
+}
+
+// near reports if wait is d with tolerance of slow test run
+func near(wait, d time.Duration) bool {
+	return wait <= d && wait > d-time.Second
+}
+
+func TestWindow(t *testing.T) {
+	rule := Rule{MaxFailures: 3, Window: time.Minute, Lockout: time.Minute}
+	tests := []struct {
+		name string
+		// time passed before each failure
+		gaps   []time.Duration
+		locked bool
+	}{
+		{"below limit", []time.Duration{0, 0}, false},
+		{"limit within window", []time.Duration{0, 0, 0}, true},
+		{"limit spread within window", []time.Duration{0, 25 * time.Second, 25 * time.Second}, true},
+		{"first failure left window", []time.Duration{0, 40 * time.Second, 40 * time.Second}, false},
+		{"old failures left window", []time.Duration{0, 0, 2 * time.Minute}, false},
+		{"limit after old failures left window", []time.Duration{0, 0, 2 * time.Minute, 0, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := New(Config{User: rule})
+			for _, gap := range tt.gaps {
+				elapse(th, gap)
+				th.Failure("user", "10.0.0.1")
+			}
+			scope, wait := th.Check("user", "10.0.0.1")
+			if locked := scope != ""; locked != tt.locked {
+				t.Fatalf("Check() = %q, %v, want locked %v", scope, wait, tt.locked)
+			}
+			if tt.locked && (scope != ScopeUser || !near(wait, rule.Lockout)) {
+				t.Errorf("Check() = %q, %v, want %q, %v", scope, wait, ScopeUser, rule.Lockout)
+			}
+		})
+	}
+}
+
+func TestScopes(t *testing.T) {
+	rule := Rule{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute}
+	tests := []struct {
+		name     string
+		cfg      Config
+		user, ip string
+		scope    string
+	}{
+		{"user from other ip", Config{User: rule}, "USER", "10.0.0.2", ScopeUser},
+		{"other user", Config{User: rule}, "other", "10.0.0.1", ""},
+		{"client ip with other user", Config{ClientIP: rule}, "other", "10.0.0.1", ScopeClientIP},
+		{"client ip from other ip", Config{ClientIP: rule}, "user", "10.0.0.2", ""},
+		{"user and ip", Config{UserIP: rule}, "User", "10.0.0.1", ScopeUserIP},
+		{"user from other ip of user and ip", Config{UserIP: rule}, "user", "10.0.0.2", ""},
+		{"disabled", Config{}, "user", "10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := New(tt.cfg)
+			th.Failure("user", "10.0.0.1")
+			th.Failure("user", "10.0.0.1")
+			if scope, _ := th.Check(tt.user, tt.ip); scope != tt.scope {
+				t.Errorf("Check() scope = %q, want %q", scope, tt.scope)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rule := Rule{Lockout: time.Minute, MaxLockout: 5 * time.Minute}
+	tests := []struct {
+		lockouts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(rule, tt.lockouts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.lockouts, got, tt.want)
+		}
+	}
+}
+
+func TestExponentialLockout(t *testing.T) {
+	th := New(Config{User: Rule{MaxFailures: 1, Window: time.Minute, Lockout: time.Minute, MaxLockout: 3 * time.Minute}})
+	var lockouts []string
+	th.SetLockoutListener(func(scope string) { lockouts = append(lockouts, scope) })
+	steps := []struct {
+		name string
+		// time passed before failure
+		gap  time.Duration
+		wait time.Duration
+	}{
+		{"first lockout", 0, time.Minute},
+		{"second lockout is twice longer", time.Minute, 2 * time.Minute},
+		{"third lockout is limited", 2 * time.Minute, 3 * time.Minute},
+		{"lockout while locked", time.Minute, 3 * time.Minute},
+		{"lockouts are reset after good behaviour", 10 * time.Minute, time.Minute},
+	}
+	for _, st := range steps {
+		elapse(th, st.gap)
+		th.Failure("user", "10.0.0.1")
+		if scope, wait := th.Check("user", "10.0.0.1"); scope != ScopeUser || !near(wait, st.wait) {
+			t.Errorf("%s: Check() = %q, %v, want %q, %v", st.name, scope, wait, ScopeUser, st.wait)
+		}
+	}
+	if len(lockouts) != len(steps) {
+		t.Errorf("lockout listener called %d times, want %d", len(lockouts), len(steps))
+	}
+	elapse(th, time.Minute)
+	if scope, wait := th.Check("user", "10.0.0.1"); scope != "" {
+		t.Errorf("after lockout: Check() = %q, %v, want allowed", scope, wait)
+	}
+}
+
+func TestSuccess(t *testing.T) {
+	rule := Rule{MaxFailures: 5, Window: time.Minute, Lockout: time.Minute}
+	th := New(Config{User: rule, ClientIP: Rule{MaxFailures: 3, Window: time.Minute, Lockout: time.Minute}, UserIP: rule})
+	th.Failure("user", "10.0.0.1")
+	th.Failure("user", "10.0.0.1")
+	th.Success("USER", "10.0.0.1")
+	th.Failure("user", "10.0.0.1")
+	tests := []struct {
+		name     string
+		scope    string
+		failures int
+	}{
+		{"user is reset", ScopeUser, 1},
+		{"user and ip is reset", ScopeUserIP, 1},
+		{"client ip is kept and locked", ScopeClientIP, 0},
+	}
+	got := make(map[string]Lockout)
+	for _, lo := range th.Lockouts() {
+		got[lo.Scope] = lo
+	}
+	for _, tt := range tests {
+		if lo := got[tt.scope]; lo.Failures != tt.failures {
+			t.Errorf("%s: failures = %d, want %d", tt.name, lo.Failures, tt.failures)
+		}
+	}
+	if scope, _ := th.Check("other", "10.0.0.1"); scope != ScopeClientIP {
+		t.Errorf("Check() scope = %q, want %q", scope, ScopeClientIP)
+	}
+}
+
+func TestSuccessKeepsLockout(t *testing.T) {
+	th := New(Config{User: Rule{MaxFailures: 1, Window: time.Minute, Lockout: time.Minute}})
+	th.Failure("user", "10.0.0.1")
+	th.Success("user", "10.0.0.1")
+	if scope, _ := th.Check("user", "10.0.0.1"); scope != ScopeUser {
+		t.Errorf("Check() scope = %q, want %q", scope, ScopeUser)
+	}
+}
+
+func TestClear(t *testing.T) {
+	rule := Rule{MaxFailures: 1, Window: time.Minute, Lockout: time.Minute}
+	tests := []struct {
+		name     string
+		user, ip string
+		removed  int
+	}{
+		{"user", "Alice", "", 2},
+		{"client ip", "", "10.0.0.2", 2},
+		{"user and ip", "alice", "10.0.0.1", 1},
+		{"user from other ip", "alice", "10.0.0.2", 0},
+		{"all", "", "", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := New(Config{User: rule, ClientIP: rule, UserIP: rule})
+			th.Failure("alice", "10.0.0.1")
+			th.Failure("bob", "10.0.0.2")
+			if n := th.Clear(tt.user, tt.ip); n != tt.removed {
+				t.Errorf("Clear() = %d, want %d", n, tt.removed)
+			}
+			left := th.Lockouts()
+			if len(left) != 6-tt.removed {
+				t.Errorf("%d keys are left, want %d", len(left), 6-tt.removed)
+			}
+			for _, lo := range left {
+				if (tt.user == "" || lo.User == "alice") && (tt.ip == "" || lo.ClientIP == tt.ip) {
+					t.Errorf("key %+v is not removed", lo)
+				}
+			}
+		})
+	}
+}
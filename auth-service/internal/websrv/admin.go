@@ -0,0 +1,80 @@
+package websrv
+
+import (
+	"auth-service/internal/apikey"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClearedResponse is number of removed lockouts or cache entries
+type ClearedResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// ReloadResponse is response of legacy reload api. Error is set if config is not reloaded
+type ReloadResponse struct {
+	Reloaded bool   `json:"reloaded,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AdminAuth checks api key of administrative api
+func (rh *RouteHandler) AdminAuth(c *gin.Context) {
+	if _, ok := rh.checkApiKey(c, apikey.ScopeAdmin); !ok {
+		return
+	}
+	c.Next()
+}
+
+// Lockouts returns state of tracked users and client ips
+func (rh *RouteHandler) Lockouts(c *gin.Context) {
+	if rh.throttle == nil {
+		rh.abort(c, http.StatusNotFound, ReasonNotFound, "Brute-force protection is disabled")
+		return
+	}
+	c.JSON(http.StatusOK, rh.throttle.Lockouts())
+}
+
+// ClearLockouts removes lockouts and failures of user and/or client ip given in query.
+// All lockouts are removed if both are empty
+func (rh *RouteHandler) ClearLockouts(c *gin.Context) {
+	if rh.throttle == nil {
+		rh.abort(c, http.StatusNotFound, ReasonNotFound, "Brute-force protection is disabled")
+		return
+	}
+	user := c.Query("user")
+	clientIP := c.Query("client_ip")
+	n := rh.throttle.Clear(user, clientIP)
+	rh.l.Infof("Lockouts cleared by admin api. User: %s, client ip: %s, removed: %d", user, clientIP, n)
+	c.JSON(http.StatusOK, &ClearedResponse{Cleared: n})
+}
+
+// ClearCache removes cached authentications of user given in query. All entries are removed if user is empty
+func (rh *RouteHandler) ClearCache(c *gin.Context) {
+	if rh.cache == nil {
+		rh.abort(c, http.StatusNotFound, ReasonNotFound, "Authentication cache is disabled")
+		return
+	}
+	user := c.Query("user")
+	n := rh.cache.Invalidate(user)
+	rh.l.Infof("Authentication cache cleared by admin api. User: %s, removed: %d", user, n)
+	c.JSON(http.StatusOK, &ClearedResponse{Cleared: n})
+}
+
+// Reload reloads config. On error current config is kept and error is returned to client
+func (rh *RouteHandler) Reload(c *gin.Context) {
+	if rh.reload == nil {
+		rh.abort(c, http.StatusNotFound, ReasonNotFound, "")
+		return
+	}
+	rh.l.Info("Config reload requested by admin api")
+	if err := rh.reload(); err != nil {
+		if isV1(c) {
+			rh.abort(c, http.StatusBadRequest, ReasonReloadFailed, err.Error())
+			return
+		}
+		c.JSON(http.StatusBadRequest, &ReloadResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, &ReloadResponse{Reloaded: true})
+}
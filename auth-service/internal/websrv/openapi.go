@@ -0,0 +1,304 @@
+package websrv
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/openapi"
+	"auth-service/internal/signing"
+	"auth-service/internal/throttle"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is version of http api in OpenAPI document
+const apiVersion = "1.0.0"
+
+const securityApiKey = "apiKey"
+
+// NewOpenAPI describes routes of web server with schemas generated from types of requests and responses.
+// Metrics are described if metricsPath is not empty. Monitoring path of legacy api is not
+// described, because it is known only to monitoring
+func NewOpenAPI(metricsPath string) *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title: "auth-service",
+		Description: "Authentication service of OpenVPN multi-provider authentication plugin. " +
+			"Callers are identified by X-Api-Key header and/or client certificate of mutual TLS. " +
+			"Routes without /v1 prefix are legacy api used by OpenVPN plugin: their errors have no body. " +
+			"If request signing is enabled, requests to api routes must have X-Signature headers.",
+		Version: apiVersion,
+	})
+	d.Components.SecuritySchemes[securityApiKey] = &openapi.SecurityScheme{Type: "apiKey", In: "header", Name: xApiKeyHeader}
+	b := &docBuilder{d: d}
+	b.auth()
+	b.accounting()
+	b.status()
+	b.admin()
+	d.Add(http.MethodGet, openapi.Path, &openapi.Operation{
+		OperationID: "openapi",
+		Summary:     "OpenAPI document of the service",
+		Tags:        []string{"meta"},
+		Responses:   map[string]*openapi.Response{"200": jsonResponse("OpenAPI document", &openapi.Schema{Type: "object"})},
+	})
+	if metricsPath != "" {
+		d.Add(http.MethodGet, metricsPath, &openapi.Operation{
+			OperationID: "metrics",
+			Summary:     "Prometheus metrics",
+			Description: "Api key is checked if keys with scope metrics are configured. Request is not signed.",
+			Tags:        []string{"meta"},
+			// api key is optional
+			Security: append(apiKeySecurity(), map[string][]string{}),
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Metrics in Prometheus text format", Content: map[string]*openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}}},
+				"403": {Description: "Api key is invalid"},
+			},
+		})
+	}
+	return d
+}
+
+type docBuilder struct {
+	d *openapi.Document
+}
+
+// add adds operation of legacy api and of v1 api. Responses of v1 api are set by v1
+func (b *docBuilder) add(method, path string, legacy *openapi.Operation, v1 func(op *openapi.Operation)) {
+	legacy.Parameters = append(legacy.Parameters, signatureParams()...)
+	legacy.Security = apiKeySecurity()
+	op := *legacy
+	op.OperationID = legacy.OperationID + "V1"
+	op.Tags = append([]string{}, legacy.Tags...)
+	op.Responses = make(map[string]*openapi.Response)
+	v1(&op)
+	legacy.Tags = append(legacy.Tags, "legacy")
+	b.d.Add(method, path, legacy)
+	b.d.Add(method, "/v1"+path, &op)
+}
+
+// errors returns responses of v1 api with ErrorResponse for statuses
+func (b *docBuilder) errors(op *openapi.Operation, statuses map[int]string) {
+	for st, desc := range statuses {
+		op.Responses[strconv.Itoa(st)] = jsonResponse(desc, b.d.Schema(ErrorResponse{}))
+	}
+}
+
+func (b *docBuilder) auth() {
+	authResp := b.d.Schema(AuthResponse{})
+	errResp := b.d.Schema(ErrorResponse{})
+	b.d.Components.Schemas["AuthResponse"].Properties["reason"].Enum = []string{
+		ReasonOK, ReasonChallenge, ReasonAuthFailed, ReasonChallengeExpired, ReasonChallengeNotSupported,
+		ReasonLockedOut, ReasonMFALimit, ReasonServersUnavailable, ReasonUpstreamTimeout,
+	}
+	b.d.Components.Schemas["ErrorResponse"].Properties["reason"].Enum = []string{
+		ReasonInvalidApiKey, ReasonInvalidSignature, ReasonInvalidRequest, ReasonNotFound,
+		ReasonAccountingFailed, ReasonReloadFailed,
+	}
+	b.add(http.MethodPost, "/auth", &openapi.Operation{
+		OperationID: "authenticate",
+		Summary:     "Authenticates user",
+		Description: "Password is the response to challenge if state is set.",
+		Tags:        []string{"auth"},
+		RequestBody: jsonBody(b.d.Schema(AuthData{})),
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "User is accepted. Body is set only by radius provider",
+				Headers:     map[string]*openapi.Header{"X-Auth-Provider": providerHeader()},
+				Content:     map[string]*openapi.MediaType{"application/json": {Schema: b.d.Schema(globals.NetworkData{})}},
+			},
+			"400": {Description: "Body of request is malformed"},
+			"401": {
+				Description: "Authentication server requires response to challenge",
+				Headers:     map[string]*openapi.Header{"X-Auth-Provider": providerHeader()},
+				Content:     map[string]*openapi.MediaType{"application/json": {Schema: b.d.Schema(globals.AuthChallenge{})}},
+			},
+			"403": {
+				Description: "User is rejected, locked out or caller is not authorized",
+				Headers:     map[string]*openapi.Header{"Retry-After": retryAfterHeader()},
+			},
+		},
+	}, func(op *openapi.Operation) {
+		headers := map[string]*openapi.Header{"X-Auth-Provider": providerHeader(), "X-Request-Id": requestIDHeader()}
+		// request which is not processed is answered with ErrorResponse
+		either := &openapi.Schema{OneOf: []*openapi.Schema{authResp, errResp}}
+		for st, r := range map[int]*openapi.Response{
+			http.StatusOK:                 jsonResponse("User is accepted", authResp),
+			http.StatusUnauthorized:       jsonResponse("Authentication server requires response to challenge", authResp),
+			http.StatusBadRequest:         jsonResponse("Body of request is malformed or challenge is not supported", either),
+			http.StatusForbidden:          jsonResponse("User is rejected, challenge is expired or caller is not authorized", either),
+			http.StatusTooManyRequests:    jsonResponse("User is locked out or limit of MFA requests is exceeded", authResp),
+			http.StatusServiceUnavailable: jsonResponse("None of authentication servers is available", authResp),
+			http.StatusGatewayTimeout:     jsonResponse("Authentication server did not answer in time", authResp),
+		} {
+			r.Headers = headers
+			op.Responses[strconv.Itoa(st)] = r
+		}
+		op.Responses["429"].Headers = map[string]*openapi.Header{"Retry-After": retryAfterHeader(), "X-Request-Id": requestIDHeader()}
+	})
+}
+
+func (b *docBuilder) accounting() {
+	body := jsonBody(b.d.Schema(globals.AccountingRecord{}))
+	b.d.Components.Schemas["AccountingRecord"].Properties["status_type"].Enum = []string{
+		globals.AcctStatusStart, globals.AcctStatusInterim, globals.AcctStatusStop,
+	}
+	b.d.Components.Schemas["AccountingRecord"].Properties["terminate_cause"].Enum = globals.AcctTerminateCauses
+	b.add(http.MethodPost, "/accounting", &openapi.Operation{
+		OperationID: "accounting",
+		Summary:     "Sends accounting record to radius accounting servers",
+		Description: "Available if accounting is enabled. Api key needs scope auth.",
+		Tags:        []string{"accounting"},
+		RequestBody: body,
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "Record is accepted"},
+			"400": {Description: "Body of request is malformed or has invalid status_type, framed_ip or terminate_cause"},
+			"403": {Description: "Caller is not authorized"},
+			"404": {Description: "Auth provider doesn't support accounting"},
+			"502": {Description: "None of accounting servers answered"},
+		},
+	}, func(op *openapi.Operation) {
+		op.Responses["204"] = &openapi.Response{Description: "Record is accepted"}
+		b.errors(op, map[int]string{
+			http.StatusBadRequest: "Body of request is malformed or has invalid status_type, framed_ip or terminate_cause",
+			http.StatusForbidden:  "Caller is not authorized",
+			http.StatusNotFound:   "Auth provider doesn't support accounting",
+			http.StatusBadGateway: "None of accounting servers answered",
+		})
+	})
+}
+
+func (b *docBuilder) status() {
+	op := &openapi.Operation{
+		OperationID: "statusV1",
+		Summary:     "Returns state of authentication servers",
+		Description: "Available if status is enabled. Api key needs scope status. " +
+			"Legacy api serves the same response on configured status path.",
+		Tags:       []string{"status"},
+		Parameters: signatureParams(),
+		Security:   apiKeySecurity(),
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("State of authentication servers", b.d.Schema(globals.MonitoringStatusResponse{})),
+		},
+	}
+	b.errors(op, map[int]string{http.StatusForbidden: "Caller is not authorized"})
+	b.d.Add(http.MethodGet, "/v1/status", op)
+}
+
+func (b *docBuilder) admin() {
+	forbidden := map[int]string{http.StatusForbidden: "Caller is not authorized"}
+	adminErrors := func(op *openapi.Operation, notFound string) {
+		b.errors(op, forbidden)
+		b.errors(op, map[int]string{http.StatusNotFound: notFound})
+	}
+	cleared := jsonResponse("Number of removed entries", b.d.Schema(ClearedResponse{}))
+	b.add(http.MethodGet, "/admin/lockouts", &openapi.Operation{
+		OperationID: "listLockouts",
+		Summary:     "Returns tracked users and client ips of brute-force protection",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("Tracked users and client ips", b.d.Schema([]throttle.Lockout{})),
+			"403": {Description: "Caller is not authorized"},
+			"404": {Description: "Brute-force protection is disabled"},
+		},
+	}, func(op *openapi.Operation) {
+		op.Responses["200"] = jsonResponse("Tracked users and client ips", b.d.Schema([]throttle.Lockout{}))
+		adminErrors(op, "Brute-force protection is disabled")
+	})
+	b.add(http.MethodDelete, "/admin/lockouts", &openapi.Operation{
+		OperationID: "clearLockouts",
+		Summary:     "Removes lockouts of user and/or client ip. All lockouts are removed if both are empty",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{queryParam("user"), queryParam("client_ip")},
+		Responses: map[string]*openapi.Response{
+			"200": cleared,
+			"403": {Description: "Caller is not authorized"},
+			"404": {Description: "Brute-force protection is disabled"},
+		},
+	}, func(op *openapi.Operation) {
+		op.Responses["200"] = cleared
+		adminErrors(op, "Brute-force protection is disabled")
+	})
+	b.add(http.MethodDelete, "/admin/cache", &openapi.Operation{
+		OperationID: "clearCache",
+		Summary:     "Removes cached authentications of user. All entries are removed if user is empty",
+		Tags:        []string{"admin"},
+		Parameters:  []*openapi.Parameter{queryParam("user")},
+		Responses: map[string]*openapi.Response{
+			"200": cleared,
+			"403": {Description: "Caller is not authorized"},
+			"404": {Description: "Authentication cache is disabled"},
+		},
+	}, func(op *openapi.Operation) {
+		op.Responses["200"] = cleared
+		adminErrors(op, "Authentication cache is disabled")
+	})
+	reload := b.d.Schema(ReloadResponse{})
+	b.add(http.MethodPost, "/admin/reload", &openapi.Operation{
+		OperationID: "reloadConfig",
+		Summary:     "Reloads config file",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": jsonResponse("Config is reloaded", reload),
+			"400": jsonResponse("Config is invalid and is not applied", reload),
+			"403": {Description: "Caller is not authorized"},
+		},
+	}, func(op *openapi.Operation) {
+		op.Responses["200"] = jsonResponse("Config is reloaded", reload)
+		b.errors(op, forbidden)
+		b.errors(op, map[int]string{http.StatusBadRequest: "Config is invalid and is not applied"})
+	})
+}
+
+func apiKeySecurity() []map[string][]string {
+	return []map[string][]string{{securityApiKey: {}}}
+}
+
+func jsonBody(s *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{"application/json": {Schema: s}}}
+}
+
+func jsonResponse(desc string, s *openapi.Schema) *openapi.Response {
+	return &openapi.Response{Description: desc, Content: map[string]*openapi.MediaType{"application/json": {Schema: s}}}
+}
+
+func queryParam(name string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Schema: &openapi.Schema{Type: "string"}}
+}
+
+func providerHeader() *openapi.Header {
+	return &openapi.Header{
+		Description: "Type of auth provider",
+		Schema:      &openapi.Schema{Type: "string", Enum: []string{globals.AuthProviderRadius, globals.AuthProviderLDAP}},
+	}
+}
+
+func retryAfterHeader() *openapi.Header {
+	return &openapi.Header{Description: "Seconds until lockout ends", Schema: &openapi.Schema{Type: "integer"}}
+}
+
+func requestIDHeader() *openapi.Header {
+	return &openapi.Header{Description: "Id of request from request header or generated", Schema: &openapi.Schema{Type: "string"}}
+}
+
+// signatureParams returns headers of signed request. They are required if request signing is enabled
+func signatureParams() []*openapi.Parameter {
+	str := &openapi.Schema{Type: "string"}
+	return []*openapi.Parameter{
+		{Name: xRequestIDHeader, In: "header", Description: "Id of request written to audit log", Schema: str},
+		{Name: signing.HeaderKey, In: "header", Description: "Name of signing key", Schema: str},
+		{Name: signing.HeaderTimestamp, In: "header", Description: "Unix time of request in seconds", Schema: &openapi.Schema{Type: "integer", Format: "int64"}},
+		{Name: signing.HeaderNonce, In: "header", Description: "Unique random string of 16-128 characters", Schema: str},
+		{Name: signing.HeaderSignature, In: "header", Description: "Hex encoded HMAC-SHA256 of METHOD, REQUEST-URI, timestamp, nonce and hex SHA-256 of body joined by new lines", Schema: str},
+	}
+}
+
+// OpenAPI serves OpenAPI document. It doesn't require api key
+func OpenAPI(d *openapi.Document) gin.HandlerFunc {
+	b, err := d.JSON()
+	return func(c *gin.Context) {
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "application/json", b)
+	}
+}
@@ -0,0 +1,66 @@
+package websrv
+
+import (
+	"auth-service/internal/metrics"
+	"auth-service/internal/signing"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maximal size of body of signed request
+const maxSignedBody = 1 << 20
+
+// VerifySignature checks HMAC signature of request if request signing is enabled.
+// Body is read to check its hash and is restored for handlers. Request is aborted with 403
+// if signature is missing, invalid, stale or replayed
+func (rh *RouteHandler) VerifySignature(c *gin.Context) {
+	v := rh.c.RequestSigning()
+	if v == nil {
+		c.Next()
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxSignedBody+1))
+	if err != nil {
+		rh.l.Errorf("Unable to read body of request %s %s. Error %s", c.Request.Method, c.Request.URL.Path, err)
+		rh.abort(c, http.StatusBadRequest, ReasonInvalidRequest, "")
+		return
+	}
+	if len(body) > maxSignedBody {
+		rh.abort(c, http.StatusRequestEntityTooLarge, ReasonInvalidRequest, "Body of request is too large")
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	name, err := v.Verify(c.Request.Header, c.Request.Method, c.Request.RequestURI, body, time.Now(), rh.nonces)
+	if err != nil {
+		metrics.SignatureRejected(signatureRejectReason(err))
+		rh.l.Warnf("Signature of request %s %s is rejected. Signing key: %s, client %s. Error %s", c.Request.Method, c.Request.URL.Path, name, c.ClientIP(), err)
+		rh.abort(c, http.StatusForbidden, ReasonInvalidSignature, "")
+		return
+	}
+	c.Next()
+}
+
+// signatureRejectReason returns label of metric for error of verification
+func signatureRejectReason(err error) string {
+	switch {
+	case errors.Is(err, signing.ErrMissing):
+		return "missing"
+	case errors.Is(err, signing.ErrUnknownKey):
+		return "unknown_key"
+	case errors.Is(err, signing.ErrTimestamp):
+		return "timestamp"
+	case errors.Is(err, signing.ErrNonce):
+		return "nonce"
+	case errors.Is(err, signing.ErrReplay):
+		return "replay"
+	case errors.Is(err, signing.ErrTooManyNonces):
+		return "too_many_nonces"
+	}
+	return "signature"
+}
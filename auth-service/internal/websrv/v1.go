@@ -0,0 +1,168 @@
+package websrv
+
+import (
+	"auth-service/internal/globals"
+	"auth-service/internal/offline"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reason codes of responses of v1 api
+const (
+	ReasonOK                    = "ok"
+	ReasonChallenge             = "challenge"
+	ReasonInvalidApiKey         = "invalid_api_key"
+	ReasonInvalidSignature      = "invalid_signature"
+	ReasonInvalidRequest        = "invalid_request"
+	ReasonAuthFailed            = "auth_failed"
+	ReasonChallengeExpired      = "challenge_expired"
+	ReasonChallengeNotSupported = "challenge_not_supported"
+	ReasonLockedOut             = "locked_out"
+	ReasonMFALimit              = "mfa_limit"
+	ReasonServersUnavailable    = "servers_unavailable"
+	ReasonUpstreamTimeout       = "upstream_timeout"
+	ReasonNotFound              = "not_found"
+	ReasonAccountingFailed      = "accounting_failed"
+	ReasonReloadFailed          = "reload_failed"
+)
+
+// messages for users, suitable for client-reason of OpenVPN. They don't disclose details
+var reasonMessages = map[string]string{
+	ReasonOK:                    "Authentication succeeded",
+	ReasonChallenge:             "Additional authentication is required",
+	ReasonInvalidApiKey:         "Caller is not authorized",
+	ReasonInvalidSignature:      "Signature of request is invalid",
+	ReasonInvalidRequest:        "Invalid request",
+	ReasonAuthFailed:            "Invalid username or password",
+	ReasonChallengeExpired:      "Authentication request has expired, please log in again",
+	ReasonChallengeNotSupported: "Additional authentication is not supported",
+	ReasonLockedOut:             "Too many failed login attempts, try again later",
+	ReasonMFALimit:              "Too many login attempts, try again later",
+	ReasonServersUnavailable:    "Authentication service is temporarily unavailable",
+	ReasonUpstreamTimeout:       "Authentication server did not answer in time, please try again",
+	ReasonNotFound:              "Not found",
+	ReasonAccountingFailed:      "Accounting server did not accept the record",
+	ReasonReloadFailed:          "Config is not reloaded",
+}
+
+// context key of requests of v1 api
+const ctxV1 = "websrv.v1"
+
+// AuthResponse is response of /v1/auth for every result
+type AuthResponse struct {
+	// accept, reject, challenge, forbidden, locked, limited or error as in audit log
+	Result string `json:"result"`
+	Reason string `json:"reason"`
+	// message for user
+	Message   string `json:"message"`
+	Provider  string `json:"provider"`
+	Server    string `json:"server,omitempty"`
+	RequestID string `json:"request_id"`
+	// set when user is locked out
+	RetryAfterSec int                    `json:"retry_after_sec,omitempty"`
+	Challenge     *globals.AuthChallenge `json:"challenge,omitempty"`
+	// settings of user returned by radius server on accept
+	NetData *globals.NetworkData `json:"network,omitempty"`
+}
+
+// ErrorResponse is returned by v1 api when request is not processed
+type ErrorResponse struct {
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// V1 marks requests of versioned api. Errors of such requests are answered with ErrorResponse
+func (rh *RouteHandler) V1(c *gin.Context) {
+	c.Set(ctxV1, true)
+	requestID(c)
+	c.Next()
+}
+
+func isV1(c *gin.Context) bool {
+	return c.GetBool(ctxV1)
+}
+
+// abort stops request with status. Legacy api answers with status only
+func (rh *RouteHandler) abort(c *gin.Context, status int, reason, msg string) {
+	if !isV1(c) {
+		c.AbortWithStatus(status)
+		return
+	}
+	if msg == "" {
+		msg = reasonMessages[reason]
+	}
+	c.AbortWithStatusJSON(status, &ErrorResponse{Reason: reason, Message: msg, RequestID: requestID(c)})
+}
+
+// bindJSON parses body of request. Legacy api answers 400 without body on error
+func (rh *RouteHandler) bindJSON(c *gin.Context, obj interface{}) bool {
+	if !isV1(c) {
+		if err := c.BindJSON(obj); err != nil {
+			rh.l.Error(err)
+			return false
+		}
+		return true
+	}
+	if err := c.ShouldBindJSON(obj); err != nil {
+		rh.l.Error(err)
+		rh.abort(c, http.StatusBadRequest, ReasonInvalidRequest, "Invalid request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// authFailure returns reason code and http status of v1 api for error of authentication
+func authFailure(err error) (string, int) {
+	switch {
+	case errors.Is(err, errMFALimit):
+		return ReasonMFALimit, http.StatusTooManyRequests
+	case errors.Is(err, errChallengeNotSupported):
+		return ReasonChallengeNotSupported, http.StatusBadRequest
+	case errors.Is(err, globals.ErrChallengeExpired):
+		return ReasonChallengeExpired, http.StatusForbidden
+	case errors.Is(err, globals.ErrServerTimeout):
+		return ReasonUpstreamTimeout, http.StatusGatewayTimeout
+	case serversDown(err), errors.Is(err, offline.ErrUnknownUser), errors.Is(err, offline.ErrExpired):
+		// degraded mode couldn't verify user while servers are down
+		return ReasonServersUnavailable, http.StatusServiceUnavailable
+	}
+	return ReasonAuthFailed, http.StatusForbidden
+}
+
+// respondAuth writes response of authentication request. Legacy api answers 403 without body
+// except to accept and challenge
+func (rh *RouteHandler) respondAuth(c *gin.Context, status int, resp *AuthResponse) {
+	if resp.RetryAfterSec > 0 {
+		c.Header("Retry-After", strconv.Itoa(resp.RetryAfterSec))
+	}
+	if isV1(c) {
+		if resp.Message == "" {
+			resp.Message = reasonMessages[resp.Reason]
+		}
+		c.Header("X-Auth-Provider", resp.Provider)
+		c.JSON(status, resp)
+		return
+	}
+	switch resp.Result {
+	case globals.OutcomeChallenge:
+		c.Header("X-Auth-Provider", resp.Provider)
+		c.JSON(http.StatusUnauthorized, resp.Challenge)
+	case globals.OutcomeAccept:
+		c.Header("X-Auth-Provider", resp.Provider)
+		if resp.Provider == globals.AuthProviderRadius {
+			netData := resp.NetData
+			if netData == nil {
+				netData = &globals.NetworkData{}
+			}
+			c.JSON(http.StatusOK, netData)
+			return
+		}
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusForbidden)
+	}
+}
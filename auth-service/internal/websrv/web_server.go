@@ -1,8 +1,18 @@
 package websrv
 
 import (
+	"auth-service/internal/apikey"
+	"auth-service/internal/audit"
+	"auth-service/internal/authcache"
+	"auth-service/internal/certs"
+	"auth-service/internal/coalesce"
 	"auth-service/internal/globals"
+	"auth-service/internal/metrics"
+	"auth-service/internal/offline"
+	"auth-service/internal/signing"
+	"auth-service/internal/throttle"
 	"crypto/tls"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -10,37 +20,70 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-var tlsClientSkipVerify = &tls.Config{InsecureSkipVerify: true}
-
 // var authApiKey string
 // var monitoringApiKey string
 type ConfigProvider interface {
-	GetAuthApiKey() string
-	GetMonitoringApiKey() string
+	ApiKeys() *apikey.Store
+	ClientIdentities() *certs.Identities
+	RequestSigning() *signing.Verifier
 	AppLogger() globals.AppLogger
 	AuthServersStatus() *globals.MonitoringStatusResponse
 	AuthProviderType() string
 }
 
 type RouteHandler struct {
-	c                ConfigProvider
-	authApiKey       string
-	monitoringApiKey string
-	l                globals.AppLogger
-	authClient       globals.AuthClientProvider
+	c          ConfigProvider
+	l          globals.AppLogger
+	authClient globals.AuthClientProvider
+	audit      *audit.Logger
+	throttle   *throttle.Throttle
+	coalesce   *coalesce.Group
+	mfaLimit   *coalesce.UserLimiter
+	cache      *authcache.Cache
+	offline    *offline.Authenticator
+	reload     func() error
+	nonces     *signing.Nonces
 }
 
-func NewRouteHandler(c ConfigProvider, authClient globals.AuthClientProvider) *RouteHandler {
+// NewRouteHandler creates handlers of web server. auditLog and thr may be nil if audit or throttling is disabled
+func NewRouteHandler(c ConfigProvider, authClient globals.AuthClientProvider, auditLog *audit.Logger, thr *throttle.Throttle) *RouteHandler {
 	return &RouteHandler{
-		c:                c,
-		authApiKey:       c.GetAuthApiKey(),
-		monitoringApiKey: c.GetMonitoringApiKey(),
-		l:                c.AppLogger(),
-		authClient:       authClient,
+		c:          c,
+		l:          c.AppLogger(),
+		authClient: authClient,
+		audit:      auditLog,
+		throttle:   thr,
+		nonces:     signing.NewNonces(signing.DefaultMaxNonces),
 	}
 }
 
-func Run(l globals.AppLogger, c globals.WebSrvConfigProvider, r *gin.Engine) *http.Server {
+// SetCache enables cache of successful authentications
+func (rh *RouteHandler) SetCache(c *authcache.Cache) {
+	rh.cache = c
+}
+
+// SetDegradedMode enables local verification of users when none of authentication servers answers
+func (rh *RouteHandler) SetDegradedMode(a *offline.Authenticator) {
+	rh.offline = a
+}
+
+// SetCoalescing enables coalescing of identical concurrent requests
+func (rh *RouteHandler) SetCoalescing(g *coalesce.Group) {
+	rh.coalesce = g
+}
+
+// SetMFALimit enables cap of requests of every user sent to authentication servers
+func (rh *RouteHandler) SetMFALimit(limiter *coalesce.UserLimiter) {
+	rh.mfaLimit = limiter
+}
+
+// SetReloader sets function which reloads config on request of admin api
+func (rh *RouteHandler) SetReloader(f func() error) {
+	rh.reload = f
+}
+
+// Run starts web server. tlsCfg must be set if https is enabled
+func Run(l globals.AppLogger, c globals.WebSrvConfigProvider, r *gin.Engine, tlsCfg *tls.Config) *http.Server {
 	p := strconv.Itoa(c.GetPort())
 	addr := c.GetAddress() + ":" + p
 	srv := &http.Server{
@@ -51,9 +94,9 @@ func Run(l globals.AppLogger, c globals.WebSrvConfigProvider, r *gin.Engine) *ht
 	}
 
 	if c.IsSSLEnabled() {
-		srv.TLSConfig = tlsClientSkipVerify
+		srv.TLSConfig = tlsCfg
 		go func() {
-			if err := srv.ListenAndServeTLS(c.Certificate(), c.PrivateKey()); err != nil && err != http.ErrServerClosed {
+			if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 				l.Error("Unable to start https web server. May wrong path to files?")
 				l.Fatal(err)
 			}
@@ -70,58 +113,335 @@ func Run(l globals.AppLogger, c globals.WebSrvConfigProvider, r *gin.Engine) *ht
 	return srv
 }
 
+// RunMetrics starts plain http server used only for metrics
+func RunMetrics(l globals.AppLogger, addr string, h http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			l.Error("Unable to start metrics web server.")
+			l.Fatal(err)
+		}
+	}()
+	l.Infof("Metrics are served on %s", addr)
+	return srv
+}
+
 type AuthData struct {
 	User     string `json:"u"`
 	Password string `json:"p"`
 	ClientIP string `json:"client_ip"`
+	// State is set when the password is the response to authentication challenge
+	State string `json:"state,omitempty"`
 }
 
-const xApiKeyHeader = "X-Api-Key"
+var (
+	errMFALimit              = errors.New("limit of MFA requests of user is exceeded")
+	errChallengeNotSupported = errors.New("challenge response is not supported")
+)
+
+const (
+	xApiKeyHeader    = "X-Api-Key"
+	xRequestIDHeader = "X-Request-Id"
+	// context key of request id
+	ctxRequestID = "websrv.request_id"
+)
 
+// AuthenticateUser serves /auth and /v1/auth
 func (rh *RouteHandler) AuthenticateUser(c *gin.Context) {
-	hv := c.GetHeader(xApiKeyHeader)
-	if hv != rh.authApiKey {
-		rh.l.Errorf("X-Api-Key is ivalid. Got from client %s", hv)
-		c.Status(http.StatusForbidden)
+	start := time.Now()
+	done := metrics.AuthStarted()
+	ev := &audit.Event{
+		RequestID: requestID(c),
+		Provider:  rh.c.AuthProviderType(),
+		Result:    globals.OutcomeReject,
+	}
+	defer func() {
+		done(ev.Result)
+		ev.Time = start
+		ev.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		rh.audit.Record(ev)
+	}()
+	cl, ok := rh.checkApiKey(c, apikey.ScopeAuth)
+	if !ok {
+		ev.Result = globals.OutcomeForbidden
+		ev.Reason = "invalid api key"
 		return
 	}
+	ev.ApiKey = cl.apiKey
+	ev.ClientCert = cl.cert
 	var authData AuthData
-	if err := c.BindJSON(&authData); err != nil {
-		rh.l.Error(err)
-		c.Status(http.StatusForbidden)
+	if !rh.bindJSON(c, &authData) {
+		ev.Result = globals.OutcomeForbidden
+		ev.Reason = "invalid request"
 		return
 	}
+	resp := &AuthResponse{Provider: ev.Provider, RequestID: ev.RequestID}
+	ev.User = authData.User
+	ev.ClientIP = authData.ClientIP
 	rh.l.Debugf("Parsed user: %s. Client ip is: %s", authData.User, authData.ClientIP)
-	r, netData, err := rh.authClient.AuthenticateUser(authData.User, authData.Password, authData.ClientIP)
+	if rh.throttle != nil {
+		if scope, wait := rh.throttle.Check(authData.User, authData.ClientIP); scope != "" {
+			rh.l.Warnf("User %s from %s is locked out by %s for %s", authData.User, authData.ClientIP, scope, wait)
+			ev.Result = globals.OutcomeLocked
+			ev.Reason = "locked out by " + scope
+			resp.Result, resp.Reason, resp.RetryAfterSec = ev.Result, ReasonLockedOut, int(wait.Seconds())+1
+			rh.respondAuth(c, http.StatusTooManyRequests, resp)
+			return
+		}
+		defer func() {
+			switch ev.Result {
+			case globals.OutcomeReject:
+				rh.throttle.Failure(authData.User, authData.ClientIP)
+			case globals.OutcomeAccept:
+				rh.throttle.Success(authData.User, authData.ClientIP)
+			}
+		}()
+	}
+	var res *globals.AuthResult
+	var err error
+	if rh.cache != nil && authData.State == "" {
+		res, ev.Cached = rh.cache.Get(authData.User, authData.ClientIP, authData.Password)
+		metrics.CacheLookup(ev.Cached)
+		defer func() {
+			switch {
+			case ev.Result == globals.OutcomeReject:
+				rh.cache.Invalidate(authData.User)
+			case ev.Degraded:
+				// accepts of degraded mode are never cached, so every such login is flagged
+			case ev.Result == globals.OutcomeAccept && !ev.Cached:
+				if err := rh.cache.Put(authData.User, authData.ClientIP, authData.Password, res); err != nil {
+					rh.l.Error(err)
+				}
+			}
+		}()
+	}
+	if rh.offline != nil && authData.State == "" {
+		defer func() {
+			switch {
+			case ev.Cached || ev.Degraded:
+			case ev.Result == globals.OutcomeReject && (err == nil || errors.Is(err, globals.ErrAuthenticationFailed)):
+				// only explicit reject of upstream, not timeouts or limits
+				go rh.offline.Forget(authData.User, authData.Password)
+			case ev.Result == globals.OutcomeAccept:
+				netData := res.NetData
+				go rh.offline.Remember(authData.User, authData.Password, netData)
+			}
+		}()
+	}
+	if !ev.Cached {
+		res, err = rh.authenticate(&authData)
+		if rh.offline != nil && authData.State == "" && noServers(err) {
+			rh.l.Errorf("None of authentication servers is available. User %s is verified in degraded mode. Error %s", authData.User, err)
+			ev.Degraded = true
+			res, err = rh.offline.Authenticate(authData.User, authData.Password)
+			if err == nil {
+				rh.l.Errorf("DEGRADED MODE: user %s from %s is accepted by %s", authData.User, authData.ClientIP, res.Server)
+				metrics.DegradedLogin(res.Server)
+			}
+		}
+	}
+	if res != nil {
+		ev.Server = res.Server
+		resp.Server = res.Server
+	}
 	if err != nil {
+		var ch *globals.AuthChallenge
+		if errors.As(err, &ch) {
+			ev.Result = globals.OutcomeChallenge
+			resp.Result, resp.Reason, resp.Message, resp.Challenge = ev.Result, ReasonChallenge, ch.Message, ch
+			rh.respondAuth(c, http.StatusUnauthorized, resp)
+			return
+		}
+		switch {
+		case errors.Is(err, errMFALimit):
+			ev.Result = globals.OutcomeLimited
+		case serversDown(err), errors.Is(err, errChallengeNotSupported):
+			ev.Result = globals.OutcomeError
+		}
+		ev.Reason = err.Error()
 		rh.l.Debug(err)
-		c.Status(http.StatusForbidden)
+		var status int
+		resp.Result = ev.Result
+		resp.Reason, status = authFailure(err)
+		rh.respondAuth(c, status, resp)
 		return
 	}
-	if !r {
-		c.Status(http.StatusForbidden)
+	if !res.Accepted {
+		ev.Reason = globals.ErrAuthenticationFailed.Error()
+		resp.Result, resp.Reason = ev.Result, ReasonAuthFailed
+		rh.respondAuth(c, http.StatusForbidden, resp)
 		return
 	}
-	rh.l.Debugf("Net data for user: %#v", netData)
-	if rh.c.AuthProviderType() == globals.AuthProviderRadius {
-		c.Header("X-Auth-Provider", "radius")
-		c.JSON(http.StatusOK, netData)
+	ev.Result = globals.OutcomeAccept
+	rh.l.Debugf("Net data for user: %#v", res.NetData)
+	resp.Result, resp.Reason, resp.NetData = ev.Result, ReasonOK, res.NetData
+	if resp.NetData != nil && resp.NetData.ReplyMessage != "" {
+		resp.Message = resp.NetData.ReplyMessage
+	}
+	rh.respondAuth(c, http.StatusOK, resp)
+}
+
+// serversDown reports if authentication failed because none of servers answered
+func serversDown(err error) bool {
+	return errors.Is(err, globals.ErrServerUnreachable) || errors.Is(err, globals.ErrNoServersAvailable)
+}
+
+// noServers reports if authentication failed before any server was contacted, because circuit
+// breakers or health checks of all servers are down. Timeouts and other failures of a contacted
+// server never start degraded mode: the server may have sent MFA push or challenge to the user
+func noServers(err error) bool {
+	return errors.Is(err, globals.ErrNoServersAvailable)
+}
+
+// authenticate sends request to authentication servers. Identical concurrent requests
+// share one upstream exchange if coalescing is enabled
+func (rh *RouteHandler) authenticate(ad *AuthData) (*globals.AuthResult, error) {
+	if rh.coalesce == nil {
+		return rh.exchange(ad)
+	}
+	key := rh.coalesce.Key(ad.User, ad.Password, ad.ClientIP, ad.State)
+	res, shared, err := rh.coalesce.Do(key, func() (*globals.AuthResult, error) {
+		return rh.exchange(ad)
+	})
+	if shared {
+		rh.l.Debugf("Request of user %s from %s is coalesced with identical pending request", ad.User, ad.ClientIP)
+		metrics.Coalesced()
+	}
+	return res, err
+}
+
+// exchange sends request to authentication servers if user is within limit of MFA requests.
+// Coalesced requests share one exchange, so they are counted once
+func (rh *RouteHandler) exchange(ad *AuthData) (*globals.AuthResult, error) {
+	// responses to challenge don't start new MFA
+	if rh.mfaLimit != nil && ad.State == "" && !rh.mfaLimit.Allow(ad.User) {
+		rh.l.Warnf("User %s exceeded limit of MFA requests", ad.User)
+		return &globals.AuthResult{}, errMFALimit
+	}
+	return rh.upstream(ad)
+}
+
+func (rh *RouteHandler) upstream(ad *AuthData) (*globals.AuthResult, error) {
+	if ad.State == "" {
+		return rh.authClient.AuthenticateUser(ad.User, ad.Password, ad.ClientIP)
+	}
+	cr, ok := rh.authClient.(globals.ChallengeResponder)
+	if !ok {
+		rh.l.Errorf("Auth provider %s doesn't support challenge response", rh.c.AuthProviderType())
+		return &globals.AuthResult{}, errChallengeNotSupported
+	}
+	return cr.ContinueAuthentication(ad.State, ad.User, ad.Password, ad.ClientIP)
+}
+
+// requestID returns id of request sent by client in X-Request-Id header or generates new one.
+// The id is returned to client in the same header
+func requestID(c *gin.Context) string {
+	if id := c.GetString(ctxRequestID); id != "" {
+		return id
+	}
+	id := c.GetHeader(xRequestIDHeader)
+	if id == "" || len(id) > 64 {
+		id = audit.NewRequestID()
+	}
+	c.Set(ctxRequestID, id)
+	c.Header(xRequestIDHeader, id)
+	return id
+}
+
+// caller is identified by name of api key and/or client certificate
+type caller struct {
+	apiKey string
+	cert   string
+}
+
+// checkApiKey identifies caller by client certificate and X-Api-Key header for scope.
+// Client certificate is enough unless api key is required in addition to it, then both are
+// checked for scopes of client certificates.
+// Request is aborted with 403 if caller is not identified
+func (rh *RouteHandler) checkApiKey(c *gin.Context, scope string) (caller, bool) {
+	var cl caller
+	ids := rh.c.ClientIdentities()
+	cert, certOK := ids.Match(c.Request.TLS, scope)
+	if certOK {
+		cl.cert = cert
+		if !ids.RequireApiKey() {
+			return cl, true
+		}
+	}
+	name, ok := rh.c.ApiKeys().Verify(c.GetHeader(xApiKeyHeader), scope, time.Now())
+	if ok && ids.RequireApiKey() && ids.Has(scope) && !certOK {
+		rh.l.Errorf("Client certificate is not valid for scope %s. Client %s", scope, c.ClientIP())
+		rh.abort(c, http.StatusForbidden, ReasonInvalidApiKey, "")
+		return cl, false
+	}
+	if !ok {
+		rh.l.Errorf("X-Api-Key is invalid or expired for scope %s. Client %s", scope, c.ClientIP())
+		rh.abort(c, http.StatusForbidden, ReasonInvalidApiKey, "")
+		return cl, false
+	}
+	cl.apiKey = name
+	return cl, true
+}
+
+// Accounting serves /accounting and /v1/accounting
+func (rh *RouteHandler) Accounting(c *gin.Context) {
+	if _, ok := rh.checkApiKey(c, apikey.ScopeAuth); !ok {
+		return
+	}
+	ap, ok := rh.authClient.(globals.AccountingProvider)
+	if !ok {
+		rh.l.Errorf("Auth provider %s doesn't support accounting", rh.c.AuthProviderType())
+		rh.abort(c, http.StatusNotFound, ReasonNotFound, "Accounting is not supported by auth provider")
+		return
+	}
+	var rec globals.AccountingRecord
+	if !rh.bindJSON(c, &rec) {
 		return
 	}
-	if rh.c.AuthProviderType() == globals.AuthProviderLDAP {
-		c.Header("X-Auth-Provider", "ldap")
-		c.Status(http.StatusOK)
+	// invalid record is rejected before any server is contacted
+	if err := rec.Validate(); err != nil {
+		rh.l.Error(err)
+		rh.abort(c, http.StatusBadRequest, ReasonInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	rh.l.Debugf("Accounting %s for user %s, session %s", rec.StatusType, rec.User, rec.SessionID)
+	err := ap.SendAccounting(&rec)
+	metrics.Accounting(rec.StatusType, err == nil)
+	if err != nil {
+		rh.l.Errorf("Unable to send accounting %s for user %s, session %s. Error %s", rec.StatusType, rec.User, rec.SessionID, err)
+		rh.abort(c, http.StatusBadGateway, ReasonAccountingFailed, "")
 		return
 	}
+	c.Status(http.StatusNoContent)
 }
 
+// Metrics serves prometheus metrics. Api key is checked if configured
+func (rh *RouteHandler) Metrics(c *gin.Context) {
+	if rh.c.ApiKeys().Has(apikey.ScopeMetrics) || rh.c.ClientIdentities().Has(apikey.ScopeMetrics) {
+		if _, ok := rh.checkApiKey(c, apikey.ScopeMetrics); !ok {
+			return
+		}
+	}
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+// Status serves monitoring path and /v1/status
 func (rh *RouteHandler) Status(c *gin.Context) {
-	hv := c.GetHeader(xApiKeyHeader)
-	if hv != rh.monitoringApiKey {
-		rh.l.Errorf("X-Api-Key is ivalid. Got from client %s", hv)
-		c.Status(http.StatusForbidden)
+	if _, ok := rh.checkApiKey(c, apikey.ScopeStatus); !ok {
 		return
 	}
 	resp := rh.c.AuthServersStatus()
+	if rh.offline != nil {
+		resp.Degraded = rh.offline.Status()
+		resp.Degraded.Active = resp.ID == globals.StatusError
+		if resp.Degraded.Active {
+			resp.Msg += ". Degraded mode is active"
+		}
+	}
 	c.JSON(http.StatusOK, resp)
 }
@@ -0,0 +1,173 @@
+package websrv
+
+import (
+	"auth-service/internal/apikey"
+	"auth-service/internal/certs"
+	"auth-service/internal/coalesce"
+	"auth-service/internal/globals"
+	"auth-service/internal/offline"
+	"auth-service/internal/pwhash"
+	"auth-service/internal/signing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testApiKey = "test-api-key"
+
+type testConfig struct {
+	keys *apikey.Store
+}
+
+func (c *testConfig) ApiKeys() *apikey.Store              { return c.keys }
+func (c *testConfig) ClientIdentities() *certs.Identities { return certs.NewIdentities(nil, false) }
+func (c *testConfig) RequestSigning() *signing.Verifier   { return nil }
+func (c *testConfig) AppLogger() globals.AppLogger        { return &globals.DummyLogger{} }
+func (c *testConfig) AuthProviderType() string            { return globals.AuthProviderRadius }
+func (c *testConfig) AuthServersStatus() *globals.MonitoringStatusResponse {
+	return &globals.MonitoringStatusResponse{}
+}
+
+// testClient answers every authentication with res and err and counts calls
+type testClient struct {
+	res   *globals.AuthResult
+	err   error
+	calls int
+}
+
+func (tc *testClient) AuthenticateUser(user, pass, clientIp string) (*globals.AuthResult, error) {
+	tc.calls++
+	return tc.res, tc.err
+}
+
+func (tc *testClient) CheckAuthenticateUser(ctx context.Context, u, p string, serverIdx int) (bool, error) {
+	return false, nil
+}
+
+func newTestHandler(t *testing.T, client globals.AuthClientProvider) *RouteHandler {
+	t.Helper()
+	keys, err := apikey.New([]apikey.Key{{Name: "vpn", Value: testApiKey, Scopes: []string{apikey.ScopeAuth}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouteHandler(&testConfig{keys: keys}, client, nil, nil)
+}
+
+// newTestOffline returns degraded mode with break-glass account admin
+func newTestOffline(t *testing.T, pass string) *offline.Authenticator {
+	t.Helper()
+	hash, err := pwhash.Hash(pass, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := offline.New(offline.Config{
+		StoreFile:  filepath.Join(t.TempDir(), "offline.json"),
+		BreakGlass: map[string]string{"admin": hash},
+	}, &globals.DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// authenticate sends /v1/auth request to rh and returns status and parsed response
+func authenticate(t *testing.T, rh *RouteHandler, user, pass string) (int, *AuthResponse) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/auth", rh.V1, rh.AuthenticateUser)
+	body := fmt.Sprintf(`{"u":%q,"p":%q,"client_ip":"10.0.0.1"}`, user, pass)
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(xApiKeyHeader, testApiKey)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	resp := &AuthResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("response %q: %s", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func TestDegradedMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		reason string
+		server string
+	}{
+		{
+			name:   "no servers available",
+			err:    fmt.Errorf("%w: all circuit breakers are open", globals.ErrNoServersAvailable),
+			status: http.StatusOK,
+			reason: ReasonOK,
+			server: offline.SourceBreakGlass,
+		},
+		{
+			// user may have ignored MFA push of the server
+			name:   "timeout",
+			err:    fmt.Errorf("%w: context deadline exceeded", globals.ErrServerTimeout),
+			status: http.StatusGatewayTimeout,
+			reason: ReasonUpstreamTimeout,
+		},
+		{
+			name:   "unreachable",
+			err:    fmt.Errorf("%w: connection refused", globals.ErrServerUnreachable),
+			status: http.StatusServiceUnavailable,
+			reason: ReasonServersUnavailable,
+		},
+		{
+			name:   "reject",
+			err:    globals.ErrAuthenticationFailed,
+			status: http.StatusForbidden,
+			reason: ReasonAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := newTestHandler(t, &testClient{res: &globals.AuthResult{}, err: tt.err})
+			rh.SetDegradedMode(newTestOffline(t, "pass"))
+			status, resp := authenticate(t, rh, "admin", "pass")
+			if status != tt.status || resp.Reason != tt.reason || resp.Server != tt.server {
+				t.Errorf("response = %d %s %q, want %d %s %q", status, resp.Reason, resp.Server, tt.status, tt.reason, tt.server)
+			}
+		})
+	}
+}
+
+func TestMFALimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		coalesce bool
+	}{
+		{"without coalescing", false},
+		{"with coalescing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &testClient{res: &globals.AuthResult{}, err: globals.ErrAuthenticationFailed}
+			rh := newTestHandler(t, client)
+			if tt.coalesce {
+				rh.SetCoalescing(coalesce.NewGroup())
+			}
+			rh.SetMFALimit(coalesce.NewUserLimiter(2, time.Minute))
+			for i, want := range []string{ReasonAuthFailed, ReasonAuthFailed, ReasonMFALimit} {
+				if _, resp := authenticate(t, rh, "user", "pass"); resp.Reason != want {
+					t.Errorf("request %d: reason = %s, want %s", i, resp.Reason, want)
+				}
+			}
+			if client.calls != 2 {
+				t.Errorf("%d requests sent to servers, want 2", client.calls)
+			}
+		})
+	}
+}
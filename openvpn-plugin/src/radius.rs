@@ -23,24 +23,36 @@ pub fn write_to_ccd(
 ) {
     let topology = "topology subnet";
     slog::debug!(logger, "{:?}", &opts);
+    let mut lines: Vec<String> = vec![];
     if opts.ip.is_some() && opts.netmask.is_some() {
-        let ipconfig = format!(
+        lines.push(topology.to_string());
+        lines.push(format!(
             "ifconfig-push {} {}",
             opts.ip.unwrap_or(String::from("")),
             opts.netmask.unwrap_or(String::from(""))
-        );
-        let contents = format!("{}\n{}", topology, ipconfig);
-        let mut fname = ccd.unwrap_or_else(|| String::from("/tmp/"));
-        if fname.ends_with("/") {
-            fname = format!("{}{}", fname, username);
-        } else {
-            fname = format!("{}/{}", fname, username);
-        }
-        match std::fs::write(&fname, &contents) {
-            Ok(_v) => {}
-            Err(e) => {
-                slog::error!(logger, "Can't write to {}. {}", &fname, e);
-            }
-        };
+        ));
+    }
+    // every route is in format "network netmask"
+    for r in opts.routes.unwrap_or_default() {
+        lines.push(format!("push \"route {}\"", r));
+    }
+    for d in opts.dns_servers.unwrap_or_default() {
+        lines.push(format!("push \"dhcp-option DNS {}\"", d));
     }
+    if lines.is_empty() {
+        return;
+    }
+    let contents = lines.join("\n");
+    let mut fname = ccd.unwrap_or_else(|| String::from("/tmp/"));
+    if fname.ends_with("/") {
+        fname = format!("{}{}", fname, username);
+    } else {
+        fname = format!("{}/{}", fname, username);
+    }
+    match std::fs::write(&fname, &contents) {
+        Ok(_v) => {}
+        Err(e) => {
+            slog::error!(logger, "Can't write to {}. {}", &fname, e);
+        }
+    };
 }